@@ -0,0 +1,239 @@
+// This file contains the links between Steam and Discord accounts.
+// A Discord user asks for a one-time code with the link command and types it into the chat of any linked game server.
+// The log parser sees the code together with the SteamID of the player, which proves that the player owns both accounts.
+// Anyone who can reach the HTTP server could post a chat event with any SteamID, so codes from there are only accepted
+// if it requires a secret
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	accountLinksFile = "links.json"
+	linkCodeLifetime = 10 * time.Minute
+	linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	linkCodeLength   = 6
+)
+
+type AccountLink struct {
+	DiscordID   string `json:"discordID"`
+	DiscordName string `json:"discordName"`
+	// the last name that was seen in game
+	PlayerName string    `json:"playerName"`
+	Linked     time.Time `json:"linked"`
+}
+
+type LinkCode struct {
+	DiscordID   string
+	DiscordName string
+	Expires     time.Time
+}
+
+type AccountLinks struct {
+	sync.Mutex
+	links map[SteamID3]*AccountLink
+	// codes that were handed out but not typed in game yet
+	codes map[string]LinkCode
+}
+
+var (
+	accountLinks    = &AccountLinks{links: make(map[SteamID3]*AccountLink), codes: make(map[string]LinkCode)}
+	linkCodePattern = regexp.MustCompile(`(?i)^\s*link\s+([a-z0-9]+)\s*$`)
+)
+
+func (store *AccountLinks) load() {
+	store.Lock()
+	defer store.Unlock()
+	path := dataFilePath(accountLinksFile)
+	if err := loadJSONFile(path, &store.links); err != nil && !os.IsNotExist(err) {
+		subsystemLogger("links").Error("Could not read account links", "path", path, "error", err)
+	}
+	if store.links == nil {
+		store.links = make(map[SteamID3]*AccountLink)
+	}
+}
+
+// has to be called with the lock held
+func (store *AccountLinks) save() {
+	path := dataFilePath(accountLinksFile)
+	if err := saveJSONFile(path, store.links); err != nil {
+		subsystemLogger("links").Error("Could not save account links", "path", path, "error", err)
+	}
+}
+
+// hands out a new code for a Discord user, older codes of the user become invalid
+func (store *AccountLinks) newCode(discordID string, discordName string) (string, error) {
+	buf := make([]byte, linkCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = linkCodeAlphabet[int(b)%len(linkCodeAlphabet)]
+	}
+	code := string(buf)
+
+	store.Lock()
+	defer store.Unlock()
+	now := time.Now()
+	for existing, linkCode := range store.codes {
+		if linkCode.DiscordID == discordID || now.After(linkCode.Expires) {
+			delete(store.codes, existing)
+		}
+	}
+	store.codes[code] = LinkCode{DiscordID: discordID, DiscordName: discordName, Expires: now.Add(linkCodeLifetime)}
+	return code, nil
+}
+
+/* links the accounts if the chat message is a valid link code
+ * returns true if the message was a link code, these messages are not forwarded to Discord
+ */
+func (store *AccountLinks) confirm(server *Server, event ChatEvent) bool {
+	matches := linkCodePattern.FindStringSubmatch(event.Message)
+	if matches == nil || event.SteamID == 0 {
+		return false
+	}
+	if event.FromHTTP && Config().HttpServer.Secret == "" {
+		serverLogger("links", server.Name).Warn("Ignoring link code from the HTTP server, set a secret in [httpserver] to accept them", "steam_id", event.SteamID.to64())
+		return false
+	}
+	code := strings.ToUpper(matches[1])
+
+	store.Lock()
+	linkCode, ok := store.codes[code]
+	if !ok || time.Now().After(linkCode.Expires) {
+		store.Unlock()
+		return false
+	}
+	delete(store.codes, code)
+	store.links[event.SteamID] = &AccountLink{
+		DiscordID:   linkCode.DiscordID,
+		DiscordName: linkCode.DiscordName,
+		PlayerName:  event.Name,
+		Linked:      time.Now(),
+	}
+	store.save()
+	store.Unlock()
+
+	serverLogger("links", server.Name).Info("Linked player to Discord user", "player", event.Name, "steam_id", event.SteamID.to64(), "discord_user", linkCode.DiscordName, "discord_id", linkCode.DiscordID)
+	queueDiscordText(server.Config().ChannelID, sanitizeUsername(event.Name)+" is now linked to <@"+linkCode.DiscordID+">", false)
+	return true
+}
+
+// removes all links of a Discord user
+func (store *AccountLinks) unlink(discordID string) int {
+	store.Lock()
+	defer store.Unlock()
+	count := 0
+	for steamID, link := range store.links {
+		if link.DiscordID == discordID {
+			delete(store.links, steamID)
+			count++
+		}
+	}
+	if count > 0 {
+		store.save()
+	}
+	return count
+}
+
+func (store *AccountLinks) get(steamID SteamID3) (AccountLink, bool) {
+	store.Lock()
+	defer store.Unlock()
+	link, ok := store.links[steamID]
+	if !ok {
+		return AccountLink{}, false
+	}
+	return *link, true
+}
+
+// returns the Steam accounts a Discord user is linked to
+func (store *AccountLinks) steamIDs(discordID string) []SteamID3 {
+	store.Lock()
+	defer store.Unlock()
+	var steamIDs []SteamID3
+	for steamID, link := range store.links {
+		if link.DiscordID == discordID {
+			steamIDs = append(steamIDs, steamID)
+		}
+	}
+	return steamIDs
+}
+
+// returns the in-game name of the player the Discord user is linked to
+func (store *AccountLinks) playerName(discordID string) (string, bool) {
+	store.Lock()
+	defer store.Unlock()
+	var newest *AccountLink
+	for _, link := range store.links {
+		if link.DiscordID == discordID && link.PlayerName != "" && (newest == nil || link.Linked.After(newest.Linked)) {
+			newest = link
+		}
+	}
+	if newest == nil {
+		return "", false
+	}
+	return newest.PlayerName, true
+}
+
+// keeps the in-game names of linked players up to date
+func (store *AccountLinks) seen(steamID SteamID3, playerName string) {
+	store.Lock()
+	defer store.Unlock()
+	if link, ok := store.links[steamID]; ok && playerName != "" && link.PlayerName != playerName {
+		link.PlayerName = playerName
+		store.save()
+	}
+}
+
+// the name of a player in Discord, with the Discord name added for linked players
+func (steamID SteamID3) displayName(playerName string) string {
+	if link, ok := accountLinks.get(steamID); ok && link.DiscordName != "" && link.DiscordName != playerName {
+		return playerName + " (" + link.DiscordName + ")"
+	}
+	return playerName
+}
+
+func (r *ResponseHandler) linkAccount() error {
+	if r.author == nil {
+		return errors.New("Accounts can only be linked inside a guild.")
+	}
+	code, err := accountLinks.newCode(r.author.User.ID, getMemberNickname(r.author))
+	if err != nil {
+		return err
+	}
+
+	// the code is sent privately, so nobody else can use it to link their Steam account
+	channel, err := r.session.UserChannelCreate(r.author.User.ID)
+	if err == nil {
+		_, err = r.session.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+			Content: "To link your Steam account, type `link " + code + "` into the chat of a game server within the next " + formatDuration(linkCodeLifetime) + ".",
+		})
+	}
+	if err != nil {
+		return errors.New("I could not send you a direct message with your code. Please allow direct messages from server members and try again.")
+	}
+	r.respond("I sent you a direct message with your code.")
+	return nil
+}
+
+func (r *ResponseHandler) unlinkAccount() error {
+	if r.author == nil {
+		return errors.New("Accounts can only be unlinked inside a guild.")
+	}
+	if accountLinks.unlink(r.author.User.ID) == 0 {
+		r.respond("Your Discord account is not linked to a Steam account.")
+		return nil
+	}
+	r.respond("Your Discord account is no longer linked to a Steam account.")
+	return nil
+}
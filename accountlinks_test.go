@@ -0,0 +1,135 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replaces the account links with empty ones until the test ends
+func useAccountLinks(t *testing.T) *AccountLinks {
+	previous := accountLinks
+	accountLinks = &AccountLinks{links: make(map[SteamID3]*AccountLink), codes: make(map[string]LinkCode)}
+	t.Cleanup(func() { accountLinks = previous })
+	return accountLinks
+}
+
+func TestNewLinkCode(t *testing.T) {
+	useConfig(t, &Configuration{})
+	store := useAccountLinks(t)
+
+	first, err := store.newCode("10", "Brute")
+	if err != nil || !regexp.MustCompile(`^[`+linkCodeAlphabet+`]{6}$`).MatchString(first) {
+		t.Fatalf("unexpected code %q, error %v", first, err)
+	}
+	other, _ := store.newCode("11", "Spammer")
+	second, _ := store.newCode("10", "Brute")
+	if second == first {
+		t.Fatalf("expected a new code, got %q twice", first)
+	}
+	// only the newest code of a user is valid
+	if _, ok := store.codes[first]; ok {
+		t.Errorf("expected the older code of the user to be invalid")
+	}
+	if code, ok := store.codes[second]; !ok || code.DiscordID != "10" || time.Until(code.Expires) > linkCodeLifetime {
+		t.Errorf("unexpected code %+v", code)
+	}
+	if _, ok := store.codes[other]; !ok {
+		t.Errorf("expected the code of the other user to stay valid")
+	}
+}
+
+func TestConfirmLinkCode(t *testing.T) {
+	fake, server := newTestBridge(t, "290", ServerConfig{})
+
+	tests := []struct {
+		name    string
+		message func(code string) string
+		expired bool
+		http    bool
+		secret  string
+		linked  bool
+	}{
+		{"right code", func(code string) string { return "link " + code }, false, false, "", true},
+		{"code in lower case", func(code string) string { return "  LINK " + strings.ToLower(code) + " " }, false, false, "", true},
+		{"wrong code", func(code string) string { return "link WRONG1" }, false, false, "", false},
+		{"no link code", func(code string) string { return "my code is " + code }, false, false, "", false},
+		{"expired code", func(code string) string { return "link " + code }, true, false, "", false},
+		{"http server without secret", func(code string) string { return "link " + code }, false, true, "", false},
+		{"http server with secret", func(code string) string { return "link " + code }, false, true, "hunter2", true},
+	}
+	for _, test := range tests {
+		config := *Config()
+		config.HttpServer.Secret = test.secret
+		setConfig(&config)
+		store := useAccountLinks(t)
+		code, _ := store.newCode("10", "Brute")
+		if test.expired {
+			linkCode := store.codes[code]
+			linkCode.Expires = time.Now().Add(-time.Second)
+			store.codes[code] = linkCode
+		}
+
+		event := ChatEvent{Name: "Bruteforce", SteamID: 11345, Message: test.message(code), FromHTTP: test.http}
+		if consumed := store.confirm(server, event); consumed != test.linked {
+			t.Errorf("%s: confirm = %v, want %v", test.name, consumed, test.linked)
+		}
+		link, ok := store.get(11345)
+		if ok != test.linked || (ok && (link.DiscordID != "10" || link.PlayerName != "Bruteforce")) {
+			t.Errorf("%s: unexpected link %+v, %v", test.name, link, ok)
+		}
+		if !test.linked {
+			continue
+		}
+		if message := fake.nextMessage(t); message.Content != "Bruteforce is now linked to <@10>" {
+			t.Errorf("%s: unexpected announcement %+v", test.name, message)
+		}
+		// a code can only be used once
+		if store.confirm(server, ChatEvent{Name: "Other", SteamID: 42, Message: "link " + code}) {
+			t.Errorf("%s: expected the code to be used up", test.name)
+		}
+	}
+}
+
+func TestLinkAndUnlink(t *testing.T) {
+	fake, server := newTestBridge(t, "291", ServerConfig{})
+	store := useAccountLinks(t)
+
+	handleDiscordMessage(discordMessageFrom("291", "10", "!link"))
+	direct := fake.nextMessage(t)
+	code := regexp.MustCompile("`link ([A-Z0-9]+)`").FindStringSubmatch(direct.Content)
+	if direct.ChannelID != "dm-10" || code == nil {
+		t.Fatalf("expected the code as direct message, got %+v", direct)
+	}
+	if message := fake.nextMessage(t); message.ChannelID != "291" || message.Content != "I sent you a direct message with your code." {
+		t.Errorf("unexpected reply %+v", message)
+	}
+
+	// the code is typed in game and does not show up in Discord
+	processLogLine(server.Name, server, discordLogLine("chat", "Bruteforce", "12345", "1", "link "+code[1]))
+	if message := fake.nextMessage(t); message.Content != "Bruteforce is now linked to <@10>" {
+		t.Errorf("unexpected announcement %+v", message)
+	}
+	if steamIDs := store.steamIDs("10"); len(steamIDs) != 1 {
+		t.Fatalf("expected one linked account, got %v", steamIDs)
+	}
+	store.links[42] = &AccountLink{DiscordID: "10", DiscordName: "Brute"}
+
+	handleDiscordMessage(discordMessageFrom("291", "10", "!unlink"))
+	if message := fake.nextMessage(t); message.Content != "Your Discord account is no longer linked to a Steam account." {
+		t.Errorf("unexpected reply %+v", message)
+	}
+	if steamIDs := store.steamIDs("10"); len(steamIDs) != 0 {
+		t.Errorf("expected all links to be removed, got %v", steamIDs)
+	}
+	links := make(map[SteamID3]*AccountLink)
+	if err := loadJSONFile(dataFilePath(accountLinksFile), &links); err != nil || len(links) != 0 {
+		t.Errorf("expected the removal to be saved, got %+v, %v", links, err)
+	}
+
+	handleDiscordMessage(discordMessageFrom("291", "10", "!unlink"))
+	if message := fake.nextMessage(t); message.Content != "Your Discord account is not linked to a Steam account." {
+		t.Errorf("unexpected reply %+v", message)
+	}
+}
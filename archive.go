@@ -0,0 +1,358 @@
+// This file contains the archive of everything the bridge sees, kept in an embedded SQLite database.
+// All events of the game servers and the messages from Discord to the game are stored, so admins can search them
+// with the search command, i.e. when handling player reports. Records are written in the background, so the log
+// parser never waits for the database
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	archiveFile          = "archive.db"
+	archiveQueueSize     = 1000
+	archivePruneInterval = time.Hour
+	archiveSearchLimit   = 15
+)
+
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	time         INTEGER NOT NULL,
+	server       TEXT NOT NULL,
+	kind         TEXT NOT NULL,
+	player       TEXT NOT NULL DEFAULT '',
+	steam_id     INTEGER NOT NULL DEFAULT 0,
+	team         INTEGER NOT NULL DEFAULT 0,
+	discord_id   TEXT NOT NULL DEFAULT '',
+	message      TEXT NOT NULL DEFAULT '',
+	map          TEXT NOT NULL DEFAULT '',
+	player_count TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE INDEX IF NOT EXISTS events_server_time ON events (server, time);
+CREATE INDEX IF NOT EXISTS events_steam_id ON events (steam_id);
+CREATE INDEX IF NOT EXISTS events_discord_id ON events (discord_id);
+`
+
+/* a single entry of the archive
+ * the kind is the kind of the log event, or "discord" for messages from Discord to the game
+ */
+type ArchiveRecord struct {
+	Time        time.Time
+	Server      string
+	Kind        string
+	Player      string
+	SteamID     SteamID3
+	Team        TeamNumber
+	DiscordID   string
+	Message     string
+	Map         string
+	PlayerCount string
+}
+
+// what the search command looks for, only one of the fields is set
+type ArchiveQuery struct {
+	Text      string
+	Player    string
+	SteamID   SteamID3
+	DiscordID string
+}
+
+type Archive struct {
+	sync.Mutex
+	db      *sql.DB
+	records chan ArchiveRecord
+	stopped chan struct{}
+	// set on shutdown, the log tailers and handlers may still be recording
+	closed bool
+}
+
+var (
+	messageArchive       = &Archive{}
+	steamID3TextPattern  = regexp.MustCompile(`^\[U:1:([0-9]+)\]$`)
+	steamID64Pattern     = regexp.MustCompile(`^7656[0-9]{13}$`)
+	archiveMentionFormat = regexp.MustCompile(`^<@!?([0-9]+)>$`)
+)
+
+func init() {
+	eventBus.subscribe(archiveLogEvent)
+}
+
+// opens the database and starts writing records, does nothing if the archive is disabled
+func (archive *Archive) open() {
+	if !Config().archiveEnabled() {
+		return
+	}
+	path := dataFilePath(archiveFile)
+	db, err := openArchiveDatabase(path)
+	if err != nil {
+		subsystemLogger("archive").Error("Could not open the archive, nothing will be archived", "path", path, "error", err)
+		return
+	}
+	archive.db = db
+	archive.records = make(chan ArchiveRecord, archiveQueueSize)
+	archive.stopped = make(chan struct{})
+	go archive.run()
+	go archive.startPruning()
+}
+
+func openArchiveDatabase(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows only one writer at a time anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(archiveSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// writes the records that are still queued and closes the database
+func (archive *Archive) close() {
+	if archive.db == nil {
+		return
+	}
+	archive.Lock()
+	archive.closed = true
+	close(archive.records)
+	archive.Unlock()
+	<-archive.stopped
+	archive.db.Close()
+}
+
+// queues a record for writing, records are dropped if the database can't keep up or the archive was closed
+func (archive *Archive) record(record ArchiveRecord) {
+	if archive.db == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	archive.Lock()
+	defer archive.Unlock()
+	if archive.closed {
+		return
+	}
+	select {
+	case archive.records <- record:
+	default:
+		serverLogger("archive", record.Server).Warn("Queue is full, dropping record", "kind", record.Kind)
+	}
+}
+
+func (archive *Archive) run() {
+	defer close(archive.stopped)
+	for record := range archive.records {
+		if err := archive.insert(record); err != nil {
+			serverLogger("archive", record.Server).Error("Could not store record", "kind", record.Kind, "error", err)
+		}
+	}
+}
+
+func (archive *Archive) insert(record ArchiveRecord) error {
+	_, err := archive.db.Exec(
+		`INSERT INTO events (time, server, kind, player, steam_id, team, discord_id, message, map, player_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Time.Unix(), record.Server, record.Kind, record.Player, int64(record.SteamID), int(record.Team),
+		record.DiscordID, record.Message, record.Map, record.PlayerCount,
+	)
+	return err
+}
+
+// removes the records that are older than the configured retention
+func (archive *Archive) startPruning() {
+	for {
+		if days := Config().Archive.RetentionDays; days > 0 {
+			before := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+			result, err := archive.db.Exec(`DELETE FROM events WHERE time < ?`, before.Unix())
+			if err != nil {
+				subsystemLogger("archive").Error("Could not remove old records", "error", err)
+			} else if count, _ := result.RowsAffected(); count > 0 {
+				subsystemLogger("archive").Info("Removed old records", "count", count, "retention_days", days)
+			}
+		}
+		time.Sleep(archivePruneInterval)
+	}
+}
+
+// returns the newest records of a server that match the query, oldest first
+func (archive *Archive) search(serverName string, query ArchiveQuery, limit int) ([]ArchiveRecord, error) {
+	where := []string{"server = ?"}
+	args := []interface{}{serverName}
+	switch {
+	case query.DiscordID != "":
+		// the messages of a Discord user include what the linked Steam accounts wrote in game
+		condition := "discord_id = ?"
+		args = append(args, query.DiscordID)
+		for _, steamID := range accountLinks.steamIDs(query.DiscordID) {
+			condition += " OR steam_id = ?"
+			args = append(args, int64(steamID))
+		}
+		where = append(where, "("+condition+")")
+	case query.SteamID != 0:
+		where = append(where, "steam_id = ?")
+		args = append(args, int64(query.SteamID))
+	case query.Player != "":
+		where = append(where, `player LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLikePattern(query.Player)+"%")
+	default:
+		where = append(where, `message LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLikePattern(query.Text)+"%")
+	}
+	args = append(args, limit)
+
+	rows, err := archive.db.Query(
+		`SELECT time, server, kind, player, steam_id, team, discord_id, message, map, player_count
+		FROM events WHERE `+strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ArchiveRecord
+	for rows.Next() {
+		var record ArchiveRecord
+		var unix, steamID int64
+		var team int
+		if err := rows.Scan(&unix, &record.Server, &record.Kind, &record.Player, &steamID, &team, &record.DiscordID, &record.Message, &record.Map, &record.PlayerCount); err != nil {
+			return nil, err
+		}
+		record.Time = time.Unix(unix, 0)
+		record.SteamID = SteamID3(steamID)
+		record.Team = TeamNumber(team)
+		records = append([]ArchiveRecord{record}, records...)
+	}
+	return records, rows.Err()
+}
+
+func escapeLikePattern(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// stores the events of the game servers
+func archiveLogEvent(server *Server, event LogEvent) {
+	record := ArchiveRecord{Server: server.Name, Kind: event.Kind()}
+	switch event := event.(type) {
+	case ChatEvent:
+		record.Player = event.Name
+		record.SteamID = event.SteamID
+		record.Team = event.Team
+		record.Message = event.Message
+	case PlayerEvent:
+		record.Player = event.Name
+		record.SteamID = event.SteamID
+		record.Message = event.Action
+		record.PlayerCount = event.PlayerCount
+	case StatusEvent:
+		record.Message = event.State
+		record.Map = event.Map
+		record.PlayerCount = event.PlayerCount
+	case ChangeMapEvent:
+		record.Map = event.Map
+		record.PlayerCount = event.PlayerCount
+	case InitEvent:
+		record.Map = event.Map
+	case AdminPrintEvent:
+		record.Message = event.Message
+	default:
+		return
+	}
+	messageArchive.record(record)
+}
+
+/* parses the argument of the search command
+ * a mention searches the messages of a Discord user, @name searches by player name,
+ * a SteamID (7656... or [U:1:n]) searches a player and everything else, including other numbers, searches the messages
+ */
+func parseArchiveQuery(text string) ArchiveQuery {
+	text = strings.TrimSpace(text)
+	if matches := archiveMentionFormat.FindStringSubmatch(text); matches != nil {
+		return ArchiveQuery{DiscordID: matches[1]}
+	}
+	if strings.HasPrefix(text, "@") && len(text) > 1 {
+		return ArchiveQuery{Player: text[1:]}
+	}
+	if matches := steamID3TextPattern.FindStringSubmatch(text); matches != nil {
+		if steamID, err := strconv.ParseUint(matches[1], 10, 32); err == nil && steamID > 0 {
+			return ArchiveQuery{SteamID: SteamID3(steamID)}
+		}
+	}
+	if steamID64Pattern.MatchString(text) {
+		if steamID, err := strconv.ParseUint(text, 10, 64); err == nil && steamID > steamBaseline && steamID-steamBaseline <= 0xFFFFFFFF {
+			return ArchiveQuery{SteamID: SteamID64(steamID).to3()}
+		}
+	}
+	return ArchiveQuery{Text: text}
+}
+
+// formats a record as a line of the search results
+func (record ArchiveRecord) String() string {
+	line := record.Time.Format("2006-01-02 15:04") + " "
+	player := record.Player
+	if record.SteamID != 0 {
+		player += " (" + record.SteamID.to64().String() + ")"
+	}
+	switch record.Kind {
+	case "chat":
+		line += player + ": " + record.Message
+	case "discord":
+		line += "[Discord] " + player + ": " + record.Message
+	case "player":
+		line += player + " " + record.Message + " (" + record.PlayerCount + ")"
+	case "status":
+		line += "Round " + record.Message + " on " + record.Map
+	case "changemap":
+		line += "Changing map to " + record.Map
+	case "init":
+		line += "Loaded " + record.Map
+	default:
+		line += record.Message
+	}
+	// the results are shown in a code block
+	return strings.ReplaceAll(line, "`", "'")
+}
+
+func (r *ResponseHandler) searchArchive() error {
+	text := r.argumentText("query")
+	if strings.TrimSpace(text) == "" {
+		return errors.New("Usage: " + commandUsagePrefix() + "search <text|@player|steamid>")
+	}
+	if messageArchive.db == nil {
+		return errors.New("The archive is disabled.")
+	}
+
+	records, err := messageArchive.search(r.server.Name, parseArchiveQuery(text), archiveSearchLimit)
+	if err != nil {
+		serverLogger("archive", r.server.Name).Error("Search failed", "query", text, "error", err)
+		return errors.New("The search failed.")
+	}
+	if len(records) == 0 {
+		r.respond("Nothing found for `" + strings.ReplaceAll(text, "`", "'") + "` on server '" + r.server.Name + "'")
+		return nil
+	}
+
+	// the oldest results are left out if the message would get too long
+	lines := make([]string, len(records))
+	for i, record := range records {
+		lines[i] = truncateUTF8(record.String(), 300)
+	}
+	header := "Latest matches on server '" + r.server.Name + "':\n```\n"
+	for len(lines) > 1 && len(header)+len(strings.Join(lines, "\n"))+4 > maxMessageLength {
+		lines = lines[1:]
+	}
+	r.respond(header + strings.Join(lines, "\n") + "\n```")
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseArchiveQuery(t *testing.T) {
+	tests := []struct {
+		text  string
+		query ArchiveQuery
+	}{
+		{"cheater", ArchiveQuery{Text: "cheater"}},
+		{"  you are a cheater ", ArchiveQuery{Text: "you are a cheater"}},
+		{"@Brute", ArchiveQuery{Player: "Brute"}},
+		{"<@125786284395462656>", ArchiveQuery{DiscordID: "125786284395462656"}},
+		{"<@!125786284395462656>", ArchiveQuery{DiscordID: "125786284395462656"}},
+		{"76561197960277073", ArchiveQuery{SteamID: 11345}},
+		{"[U:1:11345]", ArchiveQuery{SteamID: 11345}},
+		{"11345", ArchiveQuery{Text: "11345"}},
+		{"12345678901234567", ArchiveQuery{Text: "12345678901234567"}},
+		{"765611979602770731", ArchiveQuery{Text: "765611979602770731"}},
+		{"[U:1:0]", ArchiveQuery{Text: "[U:1:0]"}},
+		{"0", ArchiveQuery{Text: "0"}},
+	}
+	for _, test := range tests {
+		if query := parseArchiveQuery(test.text); query != test.query {
+			t.Errorf("parseArchiveQuery(%q) = %+v, want %+v", test.text, query, test.query)
+		}
+	}
+}
+
+func TestArchiveSearch(t *testing.T) {
+	db, err := openArchiveDatabase(filepath.Join(t.TempDir(), archiveFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	archive := &Archive{db: db}
+
+	now := time.Unix(1700000000, 0)
+	records := []ArchiveRecord{
+		{Time: now, Server: "one", Kind: "chat", Player: "Brute", SteamID: 11345, Team: 1, Message: "hello 100%"},
+		{Time: now.Add(time.Second), Server: "one", Kind: "player", Player: "Wooza", SteamID: 42, Message: "join", PlayerCount: "2/24"},
+		{Time: now.Add(2 * time.Second), Server: "one", Kind: "discord", Player: "Las", DiscordID: "1234", Message: "hello from discord"},
+		{Time: now.Add(3 * time.Second), Server: "two", Kind: "chat", Player: "Brute", SteamID: 11345, Message: "hello other server"},
+	}
+	for _, record := range records {
+		if err := archive.insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    ArchiveQuery
+		expected []ArchiveRecord
+	}{
+		{"text", ArchiveQuery{Text: "hello"}, []ArchiveRecord{records[0], records[2]}},
+		{"like wildcards are escaped", ArchiveQuery{Text: "100%"}, []ArchiveRecord{records[0]}},
+		{"player", ArchiveQuery{Player: "wooz"}, []ArchiveRecord{records[1]}},
+		{"steam id", ArchiveQuery{SteamID: 11345}, []ArchiveRecord{records[0]}},
+		{"discord user", ArchiveQuery{DiscordID: "1234"}, []ArchiveRecord{records[2]}},
+		{"nothing", ArchiveQuery{Text: "bye"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := archive.search("one", test.query, archiveSearchLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("search(%+v) = %+v, want %+v", test.query, found, test.expected)
+			}
+		})
+	}
+
+	found, _ := archive.search("one", ArchiveQuery{Text: "hello"}, 1)
+	if len(found) != 1 || found[0].Message != records[2].Message {
+		t.Errorf("search with limit 1 = %+v, want only the newest match", found)
+	}
+}
+
+func TestArchiveRecordsAfterClose(t *testing.T) {
+	useConfig(t, &Configuration{})
+	archive := &Archive{}
+	archive.open()
+	if archive.db == nil {
+		t.Fatal("expected the archive to be enabled by default")
+	}
+
+	// the log tailers keep recording while the bridge shuts down
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				archive.record(ArchiveRecord{Server: "one", Kind: "chat", Message: "hello"})
+			}
+		}()
+	}
+	archive.record(ArchiveRecord{Server: "one", Kind: "chat", Message: "before close"})
+	archive.close()
+	wg.Wait()
+	archive.record(ArchiveRecord{Server: "one", Kind: "chat", Message: "after close"})
+
+	db, err := openArchiveDatabase(dataFilePath(archiveFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM events WHERE message = 'before close'`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected the queued record to be written on close, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,164 @@
+// This file contains the audit log, which records the privileged actions that were done through the bot.
+// Every action is appended as one JSON object per line to audit.jsonl in the data directory, which is rotated when it
+// gets too big. If an audit channel is configured, the actions are also posted there
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	auditFile = "audit.jsonl"
+	// the number of rotated files that are kept next to the current one (audit.jsonl.1 is the newest)
+	auditMaxBackups = 4
+
+	auditColor       = 0x3498DB
+	auditDeniedColor = 0xE74C3C
+)
+
+// the size at which the audit log is rotated
+var auditMaxFileSize int64 = 10 * 1024 * 1024
+
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Server    string    `json:"server,omitempty"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	ActorID   string    `json:"actor_id,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Arguments string    `json:"arguments,omitempty"`
+	Outcome   string    `json:"outcome"`
+}
+
+type AuditLog struct {
+	sync.Mutex
+}
+
+var auditLog = &AuditLog{}
+
+func (log *AuditLog) record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	logger := serverLogger("audit", entry.Server)
+	logger.Info("Recorded action", "action", entry.Action, "actor", entry.Actor, "target", entry.Target, "arguments", entry.Arguments, "outcome", entry.Outcome)
+
+	if channelID := auditChannelID(entry.Server); channelID != "" && discord != nil {
+		queueDiscordEmbed(channelID, entry.embed(), false)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Could not encode audit entry", "error", err)
+		return
+	}
+	log.Lock()
+	defer log.Unlock()
+	path := dataFilePath(auditFile)
+	if err := rotateAuditFile(path, int64(len(line)+1)); err != nil {
+		logger.Error("Could not rotate audit log", "path", path, "error", err)
+	}
+	if err := appendLine(path, line); err != nil {
+		logger.Error("Could not write audit log", "path", path, "error", err)
+	}
+}
+
+// the audit channel of a server, falls back to the global one
+func auditChannelID(serverName string) string {
+	if server, ok := serverList.get(serverName); ok && server.Config().AuditChannelID != "" {
+		return server.Config().AuditChannelID
+	}
+	return Config().Discord.AuditChannelID
+}
+
+// builds an audit entry for an action of the user who invoked the command
+func (r *ResponseHandler) auditEntry(action string, arguments string, outcome string) AuditEntry {
+	entry := AuditEntry{Action: action, Arguments: arguments, Outcome: outcome}
+	if r.server != nil {
+		entry.Server = r.server.Name
+	}
+	if r.author != nil {
+		entry.Actor = getMemberNickname(r.author)
+		entry.ActorID = r.author.User.ID
+	}
+	return entry
+}
+
+// the target of an action, shown with its id so it can be found even after a rename
+func auditTarget(name string, id string) string {
+	if name == "" {
+		return id
+	}
+	return name + " (" + id + ")"
+}
+
+func (entry AuditEntry) denied() bool {
+	return strings.HasPrefix(entry.Outcome, "denied") || strings.HasPrefix(entry.Outcome, "failed")
+}
+
+func (entry AuditEntry) embed() *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     entry.Action,
+		Color:     auditColor,
+		Timestamp: entry.Time.Format(time.RFC3339),
+	}
+	if entry.denied() {
+		embed.Color = auditDeniedColor
+	}
+	addField := func(name string, value string, inline bool) {
+		if value != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: name, Value: truncateUTF8(value, 1024), Inline: inline})
+		}
+	}
+	actor := entry.Actor
+	if entry.ActorID != "" {
+		actor = "<@" + entry.ActorID + ">"
+	}
+	addField("Actor", actor, true)
+	addField("Target", entry.Target, true)
+	addField("Server", entry.Server, true)
+	if entry.Arguments != "" {
+		addField("Arguments", "`"+escapeCodeSpan(truncateUTF8(entry.Arguments, 1000))+"`", false)
+	}
+	addField("Outcome", entry.Outcome, false)
+	return embed
+}
+
+// moves the audit log aside if the line would make it too big, the oldest rotated file is removed
+func rotateAuditFile(path string, size int64) error {
+	info, err := os.Stat(path)
+	// a line that is too big on its own is written to an empty file anyway, lines are never split across files
+	if err != nil || info.Size() == 0 || info.Size()+size <= auditMaxFileSize {
+		return nil
+	}
+	for i := auditMaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
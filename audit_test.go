@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// reads the entries of an audit file, every line has to be a complete entry
+func readAuditFile(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: broken line %q: %v", path, scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLogIsRotated(t *testing.T) {
+	useConfig(t, &Configuration{})
+	previousSize := auditMaxFileSize
+	auditMaxFileSize = 300
+	t.Cleanup(func() { auditMaxFileSize = previousSize })
+	path := dataFilePath(auditFile)
+
+	// every entry is about 100 bytes, so a file holds 2 of them and the third one crosses the limit
+	record := func(i int) {
+		auditLog.record(AuditEntry{Action: "rcon", Actor: "Brute", Arguments: "sv_say " + strconv.Itoa(i), Outcome: "sent"})
+	}
+	for i := 1; i <= 3; i++ {
+		record(i)
+	}
+	rotated := readAuditFile(t, path+".1")
+	current := readAuditFile(t, path)
+	if len(rotated) != 2 || rotated[0].Arguments != "sv_say 1" || len(current) != 1 || current[0].Arguments != "sv_say 3" {
+		t.Fatalf("expected the third entry in a new file, got %+v and %+v", rotated, current)
+	}
+
+	// the oldest files are removed
+	for i := 4; i <= 20; i++ {
+		record(i)
+	}
+	for backup := 1; backup <= auditMaxBackups; backup++ {
+		info, err := os.Stat(path + "." + strconv.Itoa(backup))
+		if err != nil || info.Size() > auditMaxFileSize {
+			t.Errorf("unexpected backup %d: %v, %v", backup, info, err)
+		}
+	}
+	if _, err := os.Stat(path + "." + strconv.Itoa(auditMaxBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d backups, got %v", auditMaxBackups, err)
+	}
+	newest := readAuditFile(t, path)
+	previous := readAuditFile(t, path+".1")
+	if newest[len(newest)-1].Arguments != "sv_say 20" || previous[len(previous)-1].Arguments != "sv_say "+strconv.Itoa(20-len(newest)) {
+		t.Errorf("expected the newest entries in order, got %+v and %+v", previous, newest)
+	}
+}
+
+func TestAuditLogWritesOversizedEntries(t *testing.T) {
+	useConfig(t, &Configuration{})
+	previousSize := auditMaxFileSize
+	auditMaxFileSize = 300
+	t.Cleanup(func() { auditMaxFileSize = previousSize })
+	path := dataFilePath(auditFile)
+
+	// an entry that is bigger than the limit on its own ends up alone in a file, and no empty file is rotated
+	auditLog.record(AuditEntry{Action: "rcon", Actor: "Brute", Arguments: strings.Repeat("a", 500), Outcome: "sent"})
+	auditLog.record(AuditEntry{Action: "mute", Actor: "Brute", Outcome: "muted"})
+	if rotated := readAuditFile(t, path+".1"); len(rotated) != 1 || rotated[0].Action != "rcon" {
+		t.Errorf("expected the big entry in the rotated file, got %+v", rotated)
+	}
+	if current := readAuditFile(t, path); len(current) != 1 || current[0].Action != "mute" {
+		t.Errorf("expected the next entry in the current file, got %+v", current)
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("expected only one rotation, got %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sets up a guild with a channel that is linked to a server, and a member that talks in it.
+// every test uses its own channel, so the messages of the tests don't end up in each other's queues
+func newTestBridge(t *testing.T, channelID string, serverConfig ServerConfig) (*FakeDiscordSession, *Server) {
+	fake := useFakeDiscordSession(t)
+	guild := fake.addGuild("1", "NS2")
+	fake.addChannel(guild, channelID, "ns2-chat")
+	fake.addRole(guild, "20", "Muted")
+	fake.addMember(guild, "10", "Brute")
+	fake.addMember(guild, "11", "Spammer", "20")
+
+	useConfig(t, &Configuration{})
+	// the queues remember the message groups of the channels, which must not leak into the next run of the test
+	for _, id := range []string{channelID, serverConfig.StatusChannelID} {
+		id := id
+		resetDiscordQueue(id)
+		t.Cleanup(func() { resetDiscordQueue(id) })
+	}
+
+	serverConfig.ChannelID = channelID
+	server := newServer("test-"+channelID, &serverConfig)
+	serverList.add(server)
+	t.Cleanup(func() { serverList.remove(server.Name) })
+	return fake, server
+}
+
+// drops the queue of a channel, a new one is started with the next message
+func resetDiscordQueue(channelID string) {
+	discordQueues.Lock()
+	defer discordQueues.Unlock()
+	delete(discordQueues.queues, channelID)
+}
+
+// replaces the config until the test ends, the data files go to a temporary directory unless the config has one
+func useConfig(t *testing.T, config *Configuration) {
+	if config.Storage.DataDir == "" {
+		config.Storage.DataDir = t.TempDir()
+	}
+	previousConfig := Config()
+	setConfig(config)
+	updateCommandPattern()
+	t.Cleanup(func() {
+		setConfig(previousConfig)
+		updateCommandPattern()
+	})
+}
+
+func discordMessageFrom(channelID string, userID string, content string, mentions ...*discordgo.User) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "500",
+		ChannelID: channelID,
+		Author:    &discordgo.User{ID: userID},
+		Content:   content,
+		Mentions:  mentions,
+	}}
+}
+
+func TestChatFromGameIsPostedToDiscord(t *testing.T) {
+	fake, server := newTestBridge(t, "201", ServerConfig{})
+
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "hello world"))
+	message := fake.nextMessage(t)
+	if message.ChannelID != "201" || len(message.Embeds) != 1 {
+		t.Fatalf("expected one embed in the linked channel, got %+v", message)
+	}
+	if embed := message.Embeds[0]; embed.Author == nil || embed.Author.Name != "Brute" || embed.Description != "hello world" {
+		t.Errorf("unexpected embed %+v", embed)
+	}
+
+	// the next message of the same player is added to the first one
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "again"))
+	edit := fake.nextMessage(t)
+	if !edit.Edit || edit.ID != message.ID || len(edit.Embeds) != 1 || edit.Embeds[0].Description != "hello world\nagain" {
+		t.Errorf("expected the first message to be edited, got %+v", edit)
+	}
+}
+
+func TestChatFromGameInTextStyle(t *testing.T) {
+	fake, server := newTestBridge(t, "202", ServerConfig{MessageStyle: "text"})
+
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "2", "gg"))
+	message := fake.nextMessage(t)
+	if message.ChannelID != "202" || len(message.Embeds) != 0 || message.Content != buildTextChatMessage(server, "Brute", 2, "gg") {
+		t.Errorf("unexpected message %+v", message)
+	}
+}
+
+func TestDiscordMessageIsPostedToWebAdmin(t *testing.T) {
+	posted := make(chan url.Values, 1)
+	webAdmin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		posted <- r.PostForm
+	}))
+	defer webAdmin.Close()
+	fake, _ := newTestBridge(t, "203", ServerConfig{WebAdmin: webAdmin.URL})
+
+	mentioned, _ := fake.User("10")
+	handleDiscordMessage(discordMessageFrom("203", "10", "hi <@10>", mentioned))
+	select {
+	case form := <-posted:
+		if form.Get("request") != "discordsend" || form.Get("user") != "Brute" || form.Get("msg") != "hi @Brute" {
+			t.Errorf("unexpected form %v", form)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not posted to web admin")
+	}
+	fake.expectNoMessage(t)
+}
+
+func TestDiscordMessageGoesThroughOutboundQueue(t *testing.T) {
+	_, server := newTestBridge(t, "204", ServerConfig{Muted: DiscordIdentityList{"Muted"}})
+
+	handleDiscordMessage(discordMessageFrom("204", "11", "buy my stuff"))
+	handleDiscordMessage(discordMessageFrom("204", "10", "hello"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	messages := server.Outbound.poll(ctx, 0)
+	if len(messages) != 1 || messages[0].User != "Brute" || messages[0].Message != "hello" {
+		t.Errorf("expected only the message of the member that is not muted, got %+v", messages)
+	}
+}
+
+func TestCommandIsAnswered(t *testing.T) {
+	fake, _ := newTestBridge(t, "205", ServerConfig{})
+
+	handleDiscordMessage(discordMessageFrom("205", "10", "!version"))
+	if message := fake.nextMessage(t); message.ChannelID != "205" || message.Content != "Version "+version {
+		t.Errorf("unexpected reply %+v", message)
+	}
+
+	// messages of the bot are ignored
+	handleDiscordMessage(discordMessageFrom("205", fake.botID, "!version"))
+	fake.expectNoMessage(t)
+}
+
+func TestDirectMessagesAreIgnored(t *testing.T) {
+	fake, _ := newTestBridge(t, "271", ServerConfig{})
+
+	// i.e. a reply to the code sent by !link
+	handleDiscordMessage(discordMessageFrom("dm-10", "10", "!version"))
+	handleDiscordMessage(discordMessageFrom("dm-10", "10", "12345"))
+	fake.expectNoMessage(t)
+}
+
+func TestRconOutputIsReadFromTheLog(t *testing.T) {
+	fake, server := newTestBridge(t, "206", ServerConfig{Admins: DiscordIdentityList{"10"}})
+	server.markTailerAlive()
+
+	go handleDiscordMessage(discordMessageFrom("206", "10", "!rcon sv_status"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if messages := server.Outbound.poll(ctx, 0); len(messages) != 1 || messages[0].Type != "rcon" || messages[0].Message != "sv_status" {
+		t.Fatalf("expected the command in the outbound queue, got %+v", messages)
+	}
+	processLogLine(server.Name, server, "[12:34:56]Brute : id=12345\n")
+	processLogLine(server.Name, server, discordLogLine("unknown", "not output"))
+	processLogLine(server.Name, server, "[12:34:56]1 players\n")
+
+	expected := "Output of `sv_status` on server '" + server.Name + "':\n```\nBrute : id=12345\n1 players\n```"
+	if message := fake.nextMessage(t); message.Content != expected {
+		t.Errorf("unexpected reply %q", message.Content)
+	}
+}
+
+func TestSplitIntoPages(t *testing.T) {
+	pages := splitIntoPages("aaaa\nbb\n\ncc\ndddddddddd", 8)
+	expected := []string{"aaaa\nbb\n", "cc", "dddddddd"}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected %q, got %q", expected, pages)
+	}
+}
+
+func TestRconPolicy(t *testing.T) {
+	fake, server := newTestBridge(t, "207", ServerConfig{
+		Admins: DiscordIdentityList{"10", "11"},
+		RconPolicy: []RconRule{
+			{Identities: DiscordIdentityList{"Muted"}, Commands: []string{"sv_kick", "sv_say"}},
+		},
+	})
+
+	handleDiscordMessage(discordMessageFrom("207", "11", "!rcon sv_say hi; quit"))
+	if message := fake.nextMessage(t); message.Content != "You may not run `quit` on server '"+server.Name+"'. Allowed are: sv_kick, sv_say" {
+		t.Errorf("unexpected reply %q", message.Content)
+	}
+	handleDiscordMessage(discordMessageFrom("207", "10", "!rcon SV_SAY hi"))
+	if message := fake.nextMessage(t); message.Content != "You may not run console commands on server '"+server.Name+"'" {
+		t.Errorf("unexpected reply %q", message.Content)
+	}
+
+	handleDiscordMessage(discordMessageFrom("207", "11", "!rcon SV_SAY hi"))
+	if message := fake.nextMessage(t); message.Content != "Sent `SV_SAY hi` to server '"+server.Name+"', the output can only be shown with a log_file_path." {
+		t.Errorf("unexpected reply %q", message.Content)
+	}
+
+	audit, _ := ioutil.ReadFile(dataFilePath(auditFile))
+	if lines := strings.Split(strings.TrimSpace(string(audit)), "\n"); len(lines) != 3 || !strings.Contains(lines[0], `"outcome":"denied"`) || !strings.Contains(lines[2], `"outcome":"sent"`) {
+		t.Errorf("unexpected audit log %s", audit)
+	}
+}
+
+func TestMuteIsPostedToAuditChannel(t *testing.T) {
+	fake, server := newTestBridge(t, "208", ServerConfig{Admins: DiscordIdentityList{"10"}, AuditChannelID: "299"})
+
+	spammer, _ := fake.User("11")
+	handleDiscordMessage(discordMessageFrom("208", "10", "!mute <@11> 2h spamming", spammer))
+	messages := map[string]FakeMessage{}
+	for i := 0; i < 2; i++ {
+		message := fake.nextMessage(t)
+		messages[message.ChannelID] = message
+	}
+	if messages["208"].Content != "Muted 1 user(s) for 2h" {
+		t.Errorf("unexpected reply %+v", messages["208"])
+	}
+	audit := messages["299"]
+	if len(audit.Embeds) != 1 {
+		t.Fatalf("expected an embed in the audit channel, got %+v", audit)
+	}
+	fields := map[string]string{}
+	for _, field := range audit.Embeds[0].Fields {
+		fields[field.Name] = field.Value
+	}
+	expected := map[string]string{"Actor": "<@10>", "Target": "Spammer (11)", "Server": server.Name, "Arguments": "`2h spamming`", "Outcome": "muted"}
+	if audit.Embeds[0].Title != "mute" || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("unexpected audit embed %q %v", audit.Embeds[0].Title, fields)
+	}
+}
@@ -0,0 +1,290 @@
+// This file contains the registry of the commands the bot understands.
+// Every command describes itself, so the help message and the slash commands are generated from the registry,
+// and the checks for linked servers and admin rights are done in one place for all commands
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type CommandPermission int
+
+const (
+	PermissionEveryone CommandPermission = iota
+	PermissionAdmin
+)
+
+type Command interface {
+	Name() string
+	Aliases() []string
+	Description() string
+	Usage() string
+	Permission() CommandPermission
+	NeedsLinkedServer() bool
+	// whether the replies to the slash command are only visible to the user who invoked it
+	Ephemeral() bool
+	// options of the slash command, the values are passed to the handler like the arguments of a text command
+	Options() []*discordgo.ApplicationCommandOption
+	Handle(r *ResponseHandler) error
+}
+
+// a command that is defined by its fields and a handler function
+type BotCommand struct {
+	name              string
+	aliases           []string
+	description       string
+	usage             string
+	permission        CommandPermission
+	needsLinkedServer bool
+	ephemeral         bool
+	options           []*discordgo.ApplicationCommandOption
+	handler           func(r *ResponseHandler) error
+}
+
+type CommandRegistry struct {
+	commands []Command
+	byName   map[string]Command
+}
+
+var (
+	commandRegistry = &CommandRegistry{byName: make(map[string]Command)}
+	errNotLinked    = errors.New("Channel is not linked to any server.")
+)
+
+func init() {
+	commandRegistry.register(&BotCommand{
+		name:        "help",
+		aliases:     []string{"commands"},
+		description: "prints this help",
+		handler:     (*ResponseHandler).printHelpMessage,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "status",
+		description:       "prints a short server status",
+		needsLinkedServer: true,
+		handler:           (*ResponseHandler).requestServerStatus,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "info",
+		description:       "prints a long server info",
+		needsLinkedServer: true,
+		handler:           (*ResponseHandler).requestServerInfo,
+	})
+	commandRegistry.register(&BotCommand{
+		name:        "channelinfo",
+		description: "prints ids of the current channel, guild and roles",
+		ephemeral:   true,
+		handler:     (*ResponseHandler).printChannelInfo,
+	})
+	commandRegistry.register(&BotCommand{
+		name:        "version",
+		description: "prints the version number",
+		handler:     (*ResponseHandler).printVersion,
+	})
+	commandRegistry.register(&BotCommand{
+		name:        "link",
+		description: "links your Discord account to your Steam account",
+		handler:     (*ResponseHandler).linkAccount,
+	})
+	commandRegistry.register(&BotCommand{
+		name:        "unlink",
+		description: "removes the link to your Steam account",
+		handler:     (*ResponseHandler).unlinkAccount,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "mute",
+		usage:             "@discorduser(s) [duration] [reason]",
+		description:       "dont forward messages from user(s) to the server",
+		permission:        PermissionAdmin,
+		needsLinkedServer: true,
+		ephemeral:         true,
+		options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "The user to mute",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "duration",
+				Description: "How long the user is muted, i.e. 30m, 2h or 7d (forever if empty)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "reason",
+				Description: "Why the user is muted",
+			},
+		},
+		handler: (*ResponseHandler).muteUser,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "unmute",
+		usage:             "@discorduser(s)",
+		description:       "remove user(s) from being muted",
+		permission:        PermissionAdmin,
+		needsLinkedServer: true,
+		ephemeral:         true,
+		options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "The user to unmute",
+				Required:    true,
+			},
+		},
+		handler: (*ResponseHandler).unmuteUser,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "mutes",
+		description:       "lists the muted users",
+		permission:        PermissionAdmin,
+		needsLinkedServer: true,
+		ephemeral:         true,
+		handler:           (*ResponseHandler).listMutes,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "search",
+		usage:             "<text|@player|steamid>",
+		description:       "searches the chat and events of the linked server",
+		permission:        PermissionAdmin,
+		needsLinkedServer: true,
+		ephemeral:         true,
+		options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "query",
+				Description: "Text of a message, @player name, Discord user mention or SteamID",
+				Required:    true,
+			},
+		},
+		handler: (*ResponseHandler).searchArchive,
+	})
+	commandRegistry.register(&BotCommand{
+		name:              "rcon",
+		usage:             "<console commands>",
+		description:       "executes console commands directly on the linked server",
+		permission:        PermissionAdmin,
+		needsLinkedServer: true,
+		ephemeral:         true,
+		options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "command",
+				Description: "The console command",
+				Required:    true,
+			},
+		},
+		handler: (*ResponseHandler).sendRconCommand,
+	})
+}
+
+func (command *BotCommand) Name() string                  { return command.name }
+func (command *BotCommand) Aliases() []string             { return command.aliases }
+func (command *BotCommand) Description() string           { return command.description }
+func (command *BotCommand) Usage() string                 { return command.usage }
+func (command *BotCommand) Permission() CommandPermission { return command.permission }
+func (command *BotCommand) NeedsLinkedServer() bool       { return command.needsLinkedServer }
+func (command *BotCommand) Ephemeral() bool               { return command.ephemeral }
+
+func (command *BotCommand) Options() []*discordgo.ApplicationCommandOption {
+	return command.options
+}
+
+func (command *BotCommand) Handle(r *ResponseHandler) error {
+	return command.handler(r)
+}
+
+func (registry *CommandRegistry) register(command Command) {
+	for _, name := range append([]string{command.Name()}, command.Aliases()...) {
+		if _, exists := registry.byName[name]; exists {
+			panic("command '" + name + "' is registered twice")
+		}
+		registry.byName[name] = command
+	}
+	registry.commands = append(registry.commands, command)
+}
+
+func (registry *CommandRegistry) find(name string) (Command, bool) {
+	command, ok := registry.byName[strings.ToLower(name)]
+	return command, ok
+}
+
+// runs a command after checking that it can be used in the current channel by the current user
+func (registry *CommandRegistry) run(name string, r *ResponseHandler) error {
+	command, ok := registry.find(name)
+	if !ok {
+		return fmt.Errorf("Unknown command '%s'. Type %shelp for a list of commands.", name, commandUsagePrefix())
+	}
+
+	if server, isServerLinked := serverList.getServerByChannelID(r.channelID); isServerLinked {
+		r.server = server
+	}
+	if command.NeedsLinkedServer() && r.server == nil {
+		return errNotLinked
+	}
+	if command.Permission() == PermissionAdmin {
+		if r.server == nil {
+			return errNotLinked
+		}
+		if r.author == nil || !r.server.isAdmin(r.author) {
+			return fmt.Errorf("You are not registered as an admin for server '%s'", r.server.Name)
+		}
+	}
+	return command.Handle(r)
+}
+
+// runs a command and tells the user if it failed
+func (registry *CommandRegistry) dispatch(name string, r *ResponseHandler) {
+	if err := registry.run(name, r); err != nil {
+		r.respond(err.Error())
+	}
+}
+
+// the prefix shown in the help, slash commands are shown if the text commands are disabled
+func commandUsagePrefix() string {
+	if prefix := Config().commandPrefix(); prefix != "" {
+		return prefix
+	}
+	return "/"
+}
+
+func (registry *CommandRegistry) helpMessage() string {
+	prefix := commandUsagePrefix()
+	lines := map[CommandPermission][]string{}
+	for _, command := range registry.commands {
+		for _, name := range append([]string{command.Name()}, command.Aliases()...) {
+			usage := strings.TrimSpace(prefix + name + " " + command.Usage())
+			line := fmt.Sprintf("%-25s - %s", usage, command.Description())
+			lines[command.Permission()] = append(lines[command.Permission()], line)
+		}
+	}
+
+	help := "```\n" + strings.Join(lines[PermissionEveryone], "\n") + "\n"
+	if len(lines[PermissionAdmin]) > 0 {
+		help += "\nadmin commands:\n" + strings.Join(lines[PermissionAdmin], "\n") + "\n"
+	}
+	return help + "```"
+}
+
+// creates the slash commands for all registered commands
+func (registry *CommandRegistry) slashCommands() []*discordgo.ApplicationCommand {
+	commands := make([]*discordgo.ApplicationCommand, 0, len(registry.commands))
+	for _, command := range registry.commands {
+		description := strings.ToUpper(command.Description()[:1]) + command.Description()[1:]
+		if command.Permission() == PermissionAdmin {
+			description += " (admin only)"
+		}
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        command.Name(),
+			Description: description,
+			Options:     command.Options(),
+		})
+	}
+	return commands
+}
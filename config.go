@@ -3,21 +3,55 @@ package main
 import (
 	"github.com/naoina/toml"
 	"io/ioutil"
-	"log"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 type Configuration struct {
 	Discord struct {
-		Token        string
-		MessageStyle string
+		Token         string
+		MessageStyle  string
+		CommandPrefix *string
+		SlashCommands *bool
+		// grouping of the chat messages of the multiline style
+		MultilineGroupMinutes int
+		MultilineMaxLength    int
+		// the privileged actions of all servers are posted to this channel
+		AuditChannelID string
 	}
 	MessageStyles struct {
 		Rich MessageStyleRichConfig
 		Text MessageStyleTextConfig
 	}
+	HttpServer struct {
+		Address string
+		// required in every request if set
+		Secret string
+	}
+	Metrics struct {
+		Address string
+	}
 	Steam struct {
-		WebApiKey string
+		WebApiKey  string
+		ApiBaseUrl string
+	}
+	Logging struct {
+		Level  string
+		Format string
+	}
+	Storage struct {
+		DataDir string
+	}
+	Archive struct {
+		Enabled *bool
+		// records older than this are removed, 0 keeps them forever
+		RetentionDays int
+	}
+	LogParser struct {
+		StartPolicy       string
+		ReplayMinutes     int
+		MaxBacklogMinutes int
 	}
 	Servers map[string]ServerConfig
 }
@@ -45,17 +79,47 @@ type MessageStyleTextConfig struct {
 type ServerConfig struct {
 	ChannelID                 string
 	StatusChannelID           string
+	AuditChannelID            string
 	Admins                    DiscordIdentityList
 	Muted                     DiscordIdentityList
+	RconPolicy                []RconRule
 	KeywordNotifications      []DiscordIdentityList
 	ServerChatMessagePrefix   string
 	ServerStatusMessagePrefix string
 	ServerIconUrl             string
 	WebAdmin                  string
+	WebAdminUser              string
+	WebAdminPassword          string
 	LogFilePath               string
+	StatusBoard               bool
+	// overrides of the global message style, unset values are inherited
+	MessageStyle  string
+	MessageStyles struct {
+		Rich MessageStyleRichConfig
+		Text MessageStyleTextConfig
+	}
 }
 
-var Config Configuration
+// allows the admins that match one of the identities to run the console commands that match one of the patterns
+type RconRule struct {
+	Identities DiscordIdentityList
+	Commands   []string
+}
+
+// the active config, it is only ever replaced as a whole, so readers always see a complete one
+var activeConfig atomic.Pointer[Configuration]
+
+func init() {
+	activeConfig.Store(&Configuration{})
+}
+
+func Config() *Configuration {
+	return activeConfig.Load()
+}
+
+func setConfig(config *Configuration) {
+	activeConfig.Store(config)
+}
 
 func (config *Configuration) getColor(color []int, defaultColor int) int {
 	if len(color) != 3 {
@@ -64,25 +128,96 @@ func (config *Configuration) getColor(color []int, defaultColor int) int {
 	return color[0]*256*256 + color[1]*256 + color[2]
 }
 
-func (config *Configuration) loadConfig(configFile string) {
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		log.Println("No configuration file found in", configFile)
-		return
+// returns the style with every unset color taken from the other style
+func (style MessageStyleRichConfig) inherit(from MessageStyleRichConfig) MessageStyleRichConfig {
+	inheritColor := func(color []int, fromColor []int) []int {
+		if len(color) == 0 {
+			return fromColor
+		}
+		return color
+	}
+	return MessageStyleRichConfig{
+		PlayerJoinColor:           inheritColor(style.PlayerJoinColor, from.PlayerJoinColor),
+		PlayerLeaveColor:          inheritColor(style.PlayerLeaveColor, from.PlayerLeaveColor),
+		StatusColor:               inheritColor(style.StatusColor, from.StatusColor),
+		ChatMessageReadyRoomColor: inheritColor(style.ChatMessageReadyRoomColor, from.ChatMessageReadyRoomColor),
+		ChatMessageMarineColor:    inheritColor(style.ChatMessageMarineColor, from.ChatMessageMarineColor),
+		ChatMessageAlienColor:     inheritColor(style.ChatMessageAlienColor, from.ChatMessageAlienColor),
+		ChatMessageSpectatorColor: inheritColor(style.ChatMessageSpectatorColor, from.ChatMessageSpectatorColor),
+	}
+}
+
+// returns the style with every empty format and prefix taken from the other style
+func (style MessageStyleTextConfig) inherit(from MessageStyleTextConfig) MessageStyleTextConfig {
+	inheritString := func(value string, fromValue string) string {
+		if value == "" {
+			return fromValue
+		}
+		return value
+	}
+	return MessageStyleTextConfig{
+		ChatMessageFormat:          inheritString(style.ChatMessageFormat, from.ChatMessageFormat),
+		ChatMessageReadyRoomPrefix: inheritString(style.ChatMessageReadyRoomPrefix, from.ChatMessageReadyRoomPrefix),
+		ChatMessageMarinePrefix:    inheritString(style.ChatMessageMarinePrefix, from.ChatMessageMarinePrefix),
+		ChatMessageAlienPrefix:     inheritString(style.ChatMessageAlienPrefix, from.ChatMessageAlienPrefix),
+		ChatMessageSpectatorPrefix: inheritString(style.ChatMessageSpectatorPrefix, from.ChatMessageSpectatorPrefix),
+		PlayerJoinFormat:           inheritString(style.PlayerJoinFormat, from.PlayerJoinFormat),
+		PlayerLeaveFormat:          inheritString(style.PlayerLeaveFormat, from.PlayerLeaveFormat),
+	}
+}
+
+// messages are not added to a multiline chat message that is older than this
+func (config *Configuration) multilineGroupWindow() time.Duration {
+	if config.Discord.MultilineGroupMinutes <= 0 {
+		return 2 * time.Minute
 	}
+	return time.Duration(config.Discord.MultilineGroupMinutes) * time.Minute
+}
 
+// a new multiline chat message is started when the text would get longer than this
+func (config *Configuration) multilineMaxLength() int {
+	if config.Discord.MultilineMaxLength <= 0 {
+		return 2000
+	}
+	return config.Discord.MultilineMaxLength
+}
+
+// prefix of the text commands, an empty prefix disables them
+func (config *Configuration) commandPrefix() string {
+	if config.Discord.CommandPrefix == nil {
+		return "!"
+	}
+	return *config.Discord.CommandPrefix
+}
+
+func (config *Configuration) slashCommandsEnabled() bool {
+	return config.Discord.SlashCommands == nil || *config.Discord.SlashCommands
+}
+
+func (config *Configuration) archiveEnabled() bool {
+	return config.Archive.Enabled == nil || *config.Archive.Enabled
+}
+
+// reads the config file, the current config is not touched
+func loadConfig(configFile string) (*Configuration, error) {
 	f, err := os.Open(configFile)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 	defer f.Close()
 
-	log.Println("Reading config file", configFile)
+	subsystemLogger("config").Info("Reading config file", "path", configFile)
 	buf, err := ioutil.ReadAll(f)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
-	if err := toml.Unmarshal(buf, &Config); err != nil {
-		panic(err.Error())
+	config := &Configuration{}
+	if err := toml.Unmarshal(buf, config); err != nil {
+		return nil, err
+	}
+	if problems := config.validate(); len(problems) > 0 {
+		return nil, &ConfigValidationError{problems}
 	}
+	return config, nil
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRichStyleInherit(t *testing.T) {
+	global := MessageStyleRichConfig{
+		PlayerJoinColor:        []int{0, 255, 0},
+		PlayerLeaveColor:       []int{255, 0, 0},
+		ChatMessageMarineColor: []int{0, 0, 255},
+	}
+	server := MessageStyleRichConfig{
+		PlayerJoinColor:       []int{1, 2, 3},
+		ChatMessageAlienColor: []int{255, 128, 0},
+	}
+	expected := MessageStyleRichConfig{
+		PlayerJoinColor:        []int{1, 2, 3},
+		PlayerLeaveColor:       []int{255, 0, 0},
+		ChatMessageMarineColor: []int{0, 0, 255},
+		ChatMessageAlienColor:  []int{255, 128, 0},
+	}
+	if style := server.inherit(global); !reflect.DeepEqual(style, expected) {
+		t.Errorf("expected %+v, got %+v", expected, style)
+	}
+	if style := (MessageStyleRichConfig{}).inherit(global); !reflect.DeepEqual(style, global) {
+		t.Errorf("expected an empty style to inherit everything, got %+v", style)
+	}
+}
+
+func TestTextStyleInherit(t *testing.T) {
+	global := MessageStyleTextConfig{
+		ChatMessageFormat:       "%s %t%p: %m",
+		ChatMessageMarinePrefix: "[M] ",
+		PlayerJoinFormat:        "%p joined",
+	}
+	server := MessageStyleTextConfig{
+		ChatMessageMarinePrefix: "(Marines) ",
+		PlayerLeaveFormat:       "%p left",
+	}
+	expected := MessageStyleTextConfig{
+		ChatMessageFormat:       "%s %t%p: %m",
+		ChatMessageMarinePrefix: "(Marines) ",
+		PlayerJoinFormat:        "%p joined",
+		PlayerLeaveFormat:       "%p left",
+	}
+	if style := server.inherit(global); style != expected {
+		t.Errorf("expected %+v, got %+v", expected, style)
+	}
+}
+
+func TestServerStylesInheritTheGlobalOnes(t *testing.T) {
+	_, server := newTestBridge(t, "270", ServerConfig{ServerChatMessagePrefix: "[EU]"})
+	other := newServer("other", &ServerConfig{MessageStyle: "multiline"})
+	config := &Configuration{}
+	config.Discord.MessageStyle = "text"
+	config.MessageStyles.Text.ChatMessageFormat = "%s %t%p: %m"
+	config.MessageStyles.Text.ChatMessageMarinePrefix = "[M] "
+	config.MessageStyles.Rich.ChatMessageMarineColor = []int{0, 0, 255}
+	config.MessageStyles.Rich.ChatMessageAlienColor = []int{255, 0, 0}
+	useConfig(t, config)
+
+	server.Config().MessageStyles.Text.ChatMessageMarinePrefix = "(Marines) "
+	server.Config().MessageStyles.Rich.ChatMessageAlienColor = []int{0, 255, 0}
+
+	if style := server.messageStyle(); style != "text" {
+		t.Errorf("expected the global message style, got %q", style)
+	}
+	if style := other.messageStyle(); style != "multiline" {
+		t.Errorf("expected the message style of the server, got %q", style)
+	}
+	if text := buildTextChatMessage(server, "Brute", 1, "hello"); text != "[EU] (Marines) Brute: hello" {
+		t.Errorf("unexpected text %q", text)
+	}
+	if text := buildTextChatMessage(other, "Brute", 1, "hello"); text != " [M] Brute: hello" {
+		t.Errorf("unexpected text %q", text)
+	}
+	colors := []struct {
+		server   *Server
+		team     TeamNumber
+		expected int
+	}{
+		{server, 1, 255},
+		{server, 2, 255 * 256},
+		{other, 2, 255 * 256 * 256},
+		{server, 0, DefaultMessageColor},
+	}
+	for _, test := range colors {
+		if color := test.team.getColor(test.server); color != test.expected {
+			t.Errorf("team %d on server %s: expected color %06x, got %06x", test.team, test.server.Name, test.expected, color)
+		}
+	}
+}
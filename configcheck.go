@@ -0,0 +1,233 @@
+// This file contains the validation of the config.
+// Every problem is reported with the TOML path of the offending option, so all of them can be fixed in one go
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ConfigProblem struct {
+	Path    string
+	Message string
+}
+
+type ConfigValidationError struct {
+	Problems []ConfigProblem
+}
+
+var snowflakePattern = regexp.MustCompile(`^[0-9]+$`)
+
+func (problem ConfigProblem) String() string {
+	return problem.Path + ": " + problem.Message
+}
+
+func (err *ConfigValidationError) Error() string {
+	lines := make([]string, len(err.Problems))
+	for i, problem := range err.Problems {
+		lines[i] = problem.String()
+	}
+	return fmt.Sprintf("%d problem(s) in config:\n\t%s", len(err.Problems), strings.Join(lines, "\n\t"))
+}
+
+// checks the whole config and returns all problems that were found
+func (config *Configuration) validate() []ConfigProblem {
+	var problems []ConfigProblem
+	report := func(path string, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if config.Discord.Token == "" {
+		report("discord.token", "no Discord bot token set")
+	}
+	validateMessageStyle(report, "discord.message_style", config.Discord.MessageStyle)
+	if config.Discord.MultilineGroupMinutes < 0 {
+		report("discord.multiline_group_minutes", "must not be negative")
+	}
+	if length := config.Discord.MultilineMaxLength; length < 0 || length > maxEmbedDescriptionLength {
+		report("discord.multiline_max_length", "must be between 0 and %d", maxEmbedDescriptionLength)
+	}
+	if prefix := config.commandPrefix(); strings.ContainsAny(prefix, " \t\n") {
+		report("discord.command_prefix", "prefix %q must not contain whitespace", prefix)
+	}
+
+	validateRichStyle(report, "messagestyles.rich", config.MessageStyles.Rich)
+
+	if id := config.Discord.AuditChannelID; id != "" && !snowflakePattern.MatchString(id) {
+		report("discord.audit_channel_id", "%q is not a channel id", id)
+	}
+
+	if address := config.HttpServer.Address; address != "" {
+		if _, port, err := net.SplitHostPort(address); err != nil || !validPort(port) {
+			report("httpserver.address", "invalid address %q, expected host:port or :port", address)
+		}
+	}
+
+	if address := config.Metrics.Address; address != "" {
+		if _, port, err := net.SplitHostPort(address); err != nil || !validPort(port) {
+			report("metrics.address", "invalid address %q, expected host:port or :port", address)
+		} else if address == config.HttpServer.Address {
+			report("metrics.address", "must differ from httpserver.address")
+		}
+	}
+
+	switch strings.ToLower(config.Logging.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		report("logging.level", "unknown level %q, options are \"debug\", \"info\", \"warn\", \"error\"", config.Logging.Level)
+	}
+	switch strings.ToLower(config.Logging.Format) {
+	case "", "text", "json":
+	default:
+		report("logging.format", "unknown format %q, options are \"text\", \"json\"", config.Logging.Format)
+	}
+
+	if config.Steam.ApiBaseUrl != "" {
+		validateURL(report, "steam.api_base_url", config.Steam.ApiBaseUrl)
+	}
+
+	if config.Archive.RetentionDays < 0 {
+		report("archive.retention_days", "must not be negative")
+	}
+
+	switch config.LogParser.StartPolicy {
+	case "", "resume", "end", "replay":
+	default:
+		report("logparser.start_policy", "unknown start policy %q, options are \"resume\", \"end\", \"replay\"", config.LogParser.StartPolicy)
+	}
+	if config.LogParser.ReplayMinutes < 0 {
+		report("logparser.replay_minutes", "must not be negative")
+	}
+	if config.LogParser.MaxBacklogMinutes < 0 {
+		report("logparser.max_backlog_minutes", "must not be negative")
+	}
+
+	if len(config.Servers) == 0 {
+		report("servers", "no servers configured")
+	}
+	serverNames := make([]string, 0, len(config.Servers))
+	for name := range config.Servers {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+	channels := make(map[string]string)
+	for _, name := range serverNames {
+		server := config.Servers[name]
+		path := "servers." + name
+
+		switch {
+		case server.ChannelID == "":
+			report(path+".channelID", "no channel id set")
+		case !snowflakePattern.MatchString(server.ChannelID):
+			report(path+".channelID", "%q is not a channel id", server.ChannelID)
+		case channels[server.ChannelID] != "":
+			report(path+".channelID", "channel %s is already linked to server '%s'", server.ChannelID, channels[server.ChannelID])
+		default:
+			channels[server.ChannelID] = name
+		}
+		if server.StatusChannelID != "" && !snowflakePattern.MatchString(server.StatusChannelID) {
+			report(path+".statusChannelID", "%q is not a channel id", server.StatusChannelID)
+		}
+		if server.AuditChannelID != "" && !snowflakePattern.MatchString(server.AuditChannelID) {
+			report(path+".audit_channel_id", "%q is not a channel id", server.AuditChannelID)
+		}
+
+		validateMessageStyle(report, path+".message_style", server.MessageStyle)
+		validateRichStyle(report, path+".messagestyles.rich", server.MessageStyles.Rich)
+
+		if len(server.KeywordNotifications)%2 != 0 {
+			report(path+".keyword_notifications", "expected pairs of [keywords], [discord identities], but got %d lists", len(server.KeywordNotifications))
+		}
+
+		for i, rule := range server.RconPolicy {
+			rulePath := fmt.Sprintf("%s.rcon_policy[%d]", path, i)
+			if len(rule.Identities) == 0 {
+				report(rulePath+".identities", "no discord identities set, the rule applies to nobody")
+			}
+			if len(rule.Commands) == 0 {
+				report(rulePath+".commands", "no commands set, the rule allows nothing")
+			}
+			for _, pattern := range rule.Commands {
+				if !validRconPattern(pattern) {
+					report(rulePath+".commands", "%q is not a command pattern", pattern)
+				}
+			}
+		}
+
+		if server.WebAdmin != "" {
+			validateURL(report, path+".webadmin", server.WebAdmin)
+		} else if server.WebAdminUser != "" {
+			report(path+".webadmin_user", "credentials are set, but no webadmin")
+		}
+		if server.WebAdminPassword != "" && server.WebAdminUser == "" {
+			report(path+".webadmin_password", "a password is set, but no webadmin_user")
+		}
+		if server.ServerIconUrl != "" {
+			validateURL(report, path+".server_icon_url", server.ServerIconUrl)
+		}
+		if server.LogFilePath == "" && config.HttpServer.Address == "" {
+			report(path+".log_file_path", "no log file set and the http server is disabled, so no messages will be received from this server")
+		}
+	}
+	return problems
+}
+
+func validateMessageStyle(report func(string, string, ...interface{}), path string, style string) {
+	switch style {
+	case "", "multiline", "oneline", "text", "webhook":
+	default:
+		report(path, "unknown message style %q, options are \"multiline\", \"oneline\", \"text\", \"webhook\"", style)
+	}
+}
+
+func validateRichStyle(report func(string, string, ...interface{}), path string, rich MessageStyleRichConfig) {
+	validateColor(report, path+".chat_message_ready_room_color", rich.ChatMessageReadyRoomColor)
+	validateColor(report, path+".chat_message_marine_color", rich.ChatMessageMarineColor)
+	validateColor(report, path+".chat_message_alien_color", rich.ChatMessageAlienColor)
+	validateColor(report, path+".chat_message_spectator_color", rich.ChatMessageSpectatorColor)
+	validateColor(report, path+".player_join_color", rich.PlayerJoinColor)
+	validateColor(report, path+".player_leave_color", rich.PlayerLeaveColor)
+	validateColor(report, path+".status_color", rich.StatusColor)
+}
+
+func validateColor(report func(string, string, ...interface{}), path string, color []int) {
+	if len(color) == 0 {
+		return
+	}
+	if len(color) != 3 {
+		report(path, "expected [red, green, blue], but got %d values", len(color))
+		return
+	}
+	for _, value := range color {
+		if value < 0 || value > 255 {
+			report(path, "color values must be between 0 and 255, but got %d", value)
+			return
+		}
+	}
+}
+
+func validateURL(report func(string, string, ...interface{}), path string, value string) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		report(path, "invalid url %q: %v", value, err)
+		return
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		report(path, "invalid url %q, expected http(s)://host[:port][/path]", value)
+		return
+	}
+	if port := parsed.Port(); port != "" && !validPort(port) {
+		report(path, "invalid port %q in url %q, expected 1-65535", port, value)
+	}
+}
+
+func validPort(port string) bool {
+	number, err := strconv.Atoi(port)
+	return err == nil && number >= 1 && number <= 65535
+}
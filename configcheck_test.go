@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// a config without problems, the tests break it in one place
+func validTestConfig() *Configuration {
+	config := &Configuration{}
+	config.Discord.Token = "token"
+	config.Servers = map[string]ServerConfig{
+		"main":  {ChannelID: "200", LogFilePath: "/ns2/logs"},
+		"other": {ChannelID: "201", LogFilePath: "/ns2/other/logs"},
+	}
+	return config
+}
+
+func TestValidateConfig(t *testing.T) {
+	if problems := validTestConfig().validate(); len(problems) != 0 {
+		t.Fatalf("expected the test config to be valid, got %v", problems)
+	}
+
+	prefix := "! "
+	tests := []struct {
+		name   string
+		change func(config *Configuration, server *ServerConfig)
+		paths  []string
+	}{
+		{"no token", func(c *Configuration, s *ServerConfig) { c.Discord.Token = "" }, []string{"discord.token"}},
+		{"unknown message style", func(c *Configuration, s *ServerConfig) { c.Discord.MessageStyle = "fancy" }, []string{"discord.message_style"}},
+		{"negative group minutes", func(c *Configuration, s *ServerConfig) { c.Discord.MultilineGroupMinutes = -1 }, []string{"discord.multiline_group_minutes"}},
+		{"group too long", func(c *Configuration, s *ServerConfig) { c.Discord.MultilineMaxLength = maxEmbedDescriptionLength + 1 }, []string{"discord.multiline_max_length"}},
+		{"negative group length", func(c *Configuration, s *ServerConfig) { c.Discord.MultilineMaxLength = -1 }, []string{"discord.multiline_max_length"}},
+		{"prefix with whitespace", func(c *Configuration, s *ServerConfig) { c.Discord.CommandPrefix = &prefix }, []string{"discord.command_prefix"}},
+		{"color with two values", func(c *Configuration, s *ServerConfig) { c.MessageStyles.Rich.StatusColor = []int{1, 2} }, []string{"messagestyles.rich.status_color"}},
+		{"color out of range", func(c *Configuration, s *ServerConfig) { c.MessageStyles.Rich.PlayerJoinColor = []int{0, 256, 0} }, []string{"messagestyles.rich.player_join_color"}},
+		{"audit channel", func(c *Configuration, s *ServerConfig) { c.Discord.AuditChannelID = "#audit" }, []string{"discord.audit_channel_id"}},
+		{"http address", func(c *Configuration, s *ServerConfig) { c.HttpServer.Address = "8080" }, []string{"httpserver.address"}},
+		{"http port out of range", func(c *Configuration, s *ServerConfig) { c.HttpServer.Address = ":80800" }, []string{"httpserver.address"}},
+		{"metrics address", func(c *Configuration, s *ServerConfig) { c.Metrics.Address = "localhost" }, []string{"metrics.address"}},
+		{"metrics on the http address", func(c *Configuration, s *ServerConfig) { c.HttpServer.Address, c.Metrics.Address = ":8080", ":8080" }, []string{"metrics.address"}},
+		{"log level", func(c *Configuration, s *ServerConfig) { c.Logging.Level = "verbose" }, []string{"logging.level"}},
+		{"log format", func(c *Configuration, s *ServerConfig) { c.Logging.Format = "xml" }, []string{"logging.format"}},
+		{"steam api url", func(c *Configuration, s *ServerConfig) { c.Steam.ApiBaseUrl = "api.steampowered.com" }, []string{"steam.api_base_url"}},
+		{"archive retention", func(c *Configuration, s *ServerConfig) { c.Archive.RetentionDays = -1 }, []string{"archive.retention_days"}},
+		{"start policy", func(c *Configuration, s *ServerConfig) { c.LogParser.StartPolicy = "beginning" }, []string{"logparser.start_policy"}},
+		{"replay minutes", func(c *Configuration, s *ServerConfig) { c.LogParser.ReplayMinutes = -1 }, []string{"logparser.replay_minutes"}},
+		{"backlog minutes", func(c *Configuration, s *ServerConfig) { c.LogParser.MaxBacklogMinutes = -1 }, []string{"logparser.max_backlog_minutes"}},
+		{"no channel", func(c *Configuration, s *ServerConfig) { s.ChannelID = "" }, []string{"servers.main.channelID"}},
+		{"channel name", func(c *Configuration, s *ServerConfig) { s.ChannelID = "#ns2" }, []string{"servers.main.channelID"}},
+		{"channel linked twice", func(c *Configuration, s *ServerConfig) { s.ChannelID = "201" }, []string{"servers.other.channelID"}},
+		{"status channel", func(c *Configuration, s *ServerConfig) { s.StatusChannelID = "status" }, []string{"servers.main.statusChannelID"}},
+		{"server audit channel", func(c *Configuration, s *ServerConfig) { s.AuditChannelID = "audit" }, []string{"servers.main.audit_channel_id"}},
+		{"server message style", func(c *Configuration, s *ServerConfig) { s.MessageStyle = "fancy" }, []string{"servers.main.message_style"}},
+		{"server color", func(c *Configuration, s *ServerConfig) { s.MessageStyles.Rich.ChatMessageAlienColor = []int{0, 0, -1} }, []string{"servers.main.messagestyles.rich.chat_message_alien_color"}},
+		{"keyword notifications without identities", func(c *Configuration, s *ServerConfig) {
+			s.KeywordNotifications = []DiscordIdentityList{{"help"}}
+		}, []string{"servers.main.keyword_notifications"}},
+		{"rcon rule without identities", func(c *Configuration, s *ServerConfig) {
+			s.RconPolicy = []RconRule{{Commands: []string{"sv_kick"}}}
+		}, []string{"servers.main.rcon_policy[0].identities"}},
+		{"rcon rule without commands", func(c *Configuration, s *ServerConfig) {
+			s.RconPolicy = []RconRule{{Identities: DiscordIdentityList{"Moderator"}}}
+		}, []string{"servers.main.rcon_policy[0].commands"}},
+		{"rcon rule with invalid patterns", func(c *Configuration, s *ServerConfig) {
+			s.RconPolicy = []RconRule{{Identities: DiscordIdentityList{"Moderator"}, Commands: []string{"sv_[", "sv_kick 1", "sv_*"}}}
+		}, []string{"servers.main.rcon_policy[0].commands", "servers.main.rcon_policy[0].commands"}},
+		{"webadmin url", func(c *Configuration, s *ServerConfig) { s.WebAdmin = "localhost:8080" }, []string{"servers.main.webadmin"}},
+		{"webadmin port out of range", func(c *Configuration, s *ServerConfig) { s.WebAdmin = "http://127.0.0.1:67142" }, []string{"servers.main.webadmin"}},
+		{"webadmin port zero", func(c *Configuration, s *ServerConfig) { s.WebAdmin = "http://127.0.0.1:0" }, []string{"servers.main.webadmin"}},
+		{"webadmin user without webadmin", func(c *Configuration, s *ServerConfig) { s.WebAdminUser = "admin" }, []string{"servers.main.webadmin_user"}},
+		{"webadmin password without user", func(c *Configuration, s *ServerConfig) {
+			s.WebAdmin, s.WebAdminPassword = "http://localhost:8080", "secret"
+		}, []string{"servers.main.webadmin_password"}},
+		{"server icon url", func(c *Configuration, s *ServerConfig) { s.ServerIconUrl = "icon.png" }, []string{"servers.main.server_icon_url"}},
+		{"no log file and no http server", func(c *Configuration, s *ServerConfig) { s.LogFilePath = "" }, []string{"servers.main.log_file_path"}},
+		{"no log file with the http server", func(c *Configuration, s *ServerConfig) { s.LogFilePath, c.HttpServer.Address = "", ":8080" }, nil},
+		{"no servers", func(c *Configuration, s *ServerConfig) { c.Servers = nil }, []string{"servers"}},
+	}
+	for _, test := range tests {
+		config := validTestConfig()
+		server := config.Servers["main"]
+		test.change(config, &server)
+		if config.Servers != nil {
+			config.Servers["main"] = server
+		}
+
+		var paths []string
+		for _, problem := range config.validate() {
+			paths = append(paths, problem.Path)
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s: expected problems at %v, got %v", test.name, test.paths, paths)
+		}
+	}
+}
+
+func TestConfigValidationError(t *testing.T) {
+	config := validTestConfig()
+	config.Discord.Token = ""
+	config.Logging.Level = "verbose"
+	message := (&ConfigValidationError{config.validate()}).Error()
+	if !strings.HasPrefix(message, "2 problem(s) in config:\n\tdiscord.token: ") || !strings.Contains(message, "\n\tlogging.level: ") {
+		t.Errorf("unexpected message %q", message)
+	}
+}
@@ -0,0 +1,179 @@
+// This file reloads the config while the bridge is running, whenever the config file changes or on SIGHUP.
+// Servers are added, removed or updated without dropping the Discord session or the log positions.
+// If the new config can't be read, the old one stays active
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// editors often write a file in several steps, so wait a moment before reading it
+const configReloadDelay = 500 * time.Millisecond
+
+var reloadLock sync.Mutex
+
+func watchConfig() {
+	reload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			subsystemLogger("config").Info("Received SIGHUP, reloading config")
+			requestReload()
+		}
+	}()
+
+	go func() {
+		watcher := newDirWatcher(filepath.Dir(configFile))
+		defer watcher.Close()
+		lastInfo, _ := os.Stat(configFile)
+		for watcher.wait(nil) {
+			info, err := os.Stat(configFile)
+			if err != nil {
+				continue
+			}
+			if lastInfo == nil || !info.ModTime().Equal(lastInfo.ModTime()) || info.Size() != lastInfo.Size() {
+				lastInfo = info
+				subsystemLogger("config").Info("Config file changed, reloading config")
+				requestReload()
+			}
+		}
+	}()
+
+	go func() {
+		for range reload {
+			time.Sleep(configReloadDelay)
+			reloadConfig()
+		}
+	}()
+}
+
+// reads the config file again and applies it, keeping the current config if that fails
+func reloadConfig() bool {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	logger := subsystemLogger("config")
+	config, err := loadConfig(configFile)
+	if err != nil {
+		logger.Error("Could not reload config, keeping the current one", "error", err)
+		auditLog.record(AuditEntry{Action: "config reload", Actor: "bridge", Arguments: configFile, Outcome: "failed: " + err.Error()})
+		return false
+	}
+
+	if config.Discord.Token != Config().Discord.Token {
+		logger.Warn("The Discord token changed, restart the bridge to apply it")
+	}
+	if config.HttpServer.Address != Config().HttpServer.Address {
+		logger.Warn("The HTTP server address changed, restart the bridge to apply it")
+	}
+	if config.Metrics.Address != Config().Metrics.Address {
+		logger.Warn("The metrics address changed, restart the bridge to apply it")
+	}
+	if config.slashCommandsEnabled() != Config().slashCommandsEnabled() {
+		logger.Warn("Slash commands were toggled, restart the bridge to apply it")
+	}
+
+	if config.archiveEnabled() != Config().archiveEnabled() {
+		logger.Warn("The archive was toggled, restart the bridge to apply it")
+	}
+
+	if !strings.EqualFold(config.Logging.Format, Config().Logging.Format) {
+		logger.Warn("The log format changed, restart the bridge to apply it")
+	}
+
+	// everything is prepared before it is published, so the other goroutines never see a half applied config
+	changes := planServerChanges(config)
+	setConfig(config)
+	logLevel.Set(parseLogLevel(config.Logging.Level))
+	updateCommandPattern()
+	changes.apply()
+	// i.e. the Manage Webhooks permission might have been granted along with the reload
+	channelWebhooks.forgetFailures()
+	subsystemLogger("config").Info("Config reloaded")
+	auditLog.record(AuditEntry{Action: "config reload", Actor: "bridge", Arguments: configFile, Outcome: "applied"})
+	return true
+}
+
+// the changes to the linked servers that make them match a config
+type ServerChanges struct {
+	removed []*Server
+	added   []*Server
+	updated []ServerUpdate
+}
+
+type ServerUpdate struct {
+	server         *Server
+	config         *ServerConfig
+	logFileChanged bool
+}
+
+// links, updates and unlinks the servers, so they match the given config
+func applyServerConfigs(config *Configuration) {
+	planServerChanges(config).apply()
+}
+
+func planServerChanges(config *Configuration) ServerChanges {
+	var changes ServerChanges
+	for _, server := range serverList.all() {
+		if _, ok := config.Servers[server.Name]; !ok {
+			changes.removed = append(changes.removed, server)
+		}
+	}
+
+	for serverName, v := range config.Servers {
+		serverConfig := v
+		server, ok := serverList.get(serverName)
+		if !ok {
+			changes.added = append(changes.added, newServer(serverName, &serverConfig))
+			continue
+		}
+		changes.updated = append(changes.updated, ServerUpdate{
+			server:         server,
+			config:         &serverConfig,
+			logFileChanged: server.Config().LogFilePath != serverConfig.LogFilePath,
+		})
+	}
+	return changes
+}
+
+func (changes ServerChanges) apply() {
+	for _, server := range changes.removed {
+		server.stopLogTailer()
+		serverList.remove(server.Name)
+		serverLogger("config", server.Name).Info("Unlinked server")
+	}
+
+	for _, update := range changes.updated {
+		server := update.server
+		if server.Config().ChannelID != update.config.ChannelID {
+			serverLogger("config", server.Name).Info("Linked server", "channel_id", update.config.ChannelID)
+		}
+		server.config.Store(update.config)
+		server.setConfigMutes(update.config.Muted)
+		if update.logFileChanged {
+			server.stopLogTailer()
+			server.startLogTailer()
+		}
+	}
+
+	for _, server := range changes.added {
+		serverList.add(server)
+		serverLogger("config", server.Name).Info("Linked server", "channel_id", server.Config().ChannelID)
+		server.startLogTailer()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloads the config while Discord messages are handled, run with -race to check that nothing is shared unsynchronized
+func TestReloadConfigWhileRunning(t *testing.T) {
+	fake := useFakeDiscordSession(t)
+	guild := fake.addGuild("1", "NS2")
+	fake.addChannel(guild, "230", "ns2-chat")
+	fake.addMember(guild, "11", "Spammer")
+	useConfig(t, &Configuration{})
+
+	previousConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "config.toml")
+	defer func() { configFile = previousConfigFile }()
+	defer func() {
+		for _, server := range serverList.all() {
+			serverList.remove(server.Name)
+		}
+	}()
+
+	configs := []string{`
+[discord]
+token = "token"
+command_prefix = "!"
+[httpserver]
+address = ":8080"
+[servers.reload]
+channel_id = "230"
+muted = ["11"]
+`, `
+[discord]
+token = "token"
+command_prefix = "?"
+[httpserver]
+address = ":8080"
+[servers.reload]
+channel_id = "230"
+message_style = "text"
+`}
+	writeConfigFile(t, configFile, configs[0])
+	if !reloadConfig() {
+		t.Fatal("expected the config to be loaded")
+	}
+	server, ok := serverList.get("reload")
+	if !ok {
+		t.Fatal("expected the server to be linked")
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			member := &discordgo.Member{GuildID: "1", User: &discordgo.User{ID: "11"}}
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if server, ok := serverList.getServerByChannelID("230"); ok {
+					server.isMuted(member)
+					server.messageStyle()
+				}
+				Config().commandPrefix()
+				handleDiscordMessage(discordMessageFrom("230", "11", "hello"))
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		writeConfigFile(t, configFile, configs[i%2])
+		if !reloadConfig() {
+			t.Fatal("expected the config to be reloaded")
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	if current, _ := serverList.get("reload"); current != server {
+		t.Error("expected the server to be updated instead of replaced")
+	}
+	if Config().commandPrefix() != "?" || server.Config().MessageStyle != "text" || len(server.configMutes()) != 0 {
+		t.Errorf("expected the last config to be applied, got prefix %q, style %q, mutes %v", Config().commandPrefix(), server.Config().MessageStyle, server.configMutes())
+	}
+
+	// a broken config keeps the current one
+	writeConfigFile(t, configFile, `[servers.reload]`)
+	if reloadConfig() {
+		t.Error("expected the broken config to be rejected")
+	}
+	if Config().commandPrefix() != "?" || server.Config().ChannelID != "230" {
+		t.Error("expected the current config to stay active")
+	}
+}
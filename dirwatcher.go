@@ -0,0 +1,65 @@
+// This file contains the watcher that wakes up the log parser (or the config reload) when a directory changes.
+// On Linux the directory is watched with inotify, on other systems (or if inotify fails) it falls back to polling
+
+package main
+
+import (
+	"time"
+)
+
+const (
+	dirPollInterval = 500 * time.Millisecond
+	// even with inotify we wake up from time to time, in case an event got lost (i.e. on network filesystems)
+	dirWatchTimeout = 5 * time.Second
+)
+
+type DirWatcher struct {
+	events  chan struct{}
+	close   func() error
+	polling bool
+}
+
+// creates a watcher for the given directory, falling back to polling if the directory can't be watched
+func newDirWatcher(dir string) *DirWatcher {
+	watcher := &DirWatcher{
+		events: make(chan struct{}, 1),
+	}
+	closeFunc, err := watchDirectory(dir, watcher.notify)
+	if err != nil {
+		subsystemLogger("dirwatcher").Warn("Could not watch directory, falling back to polling", "dir", dir, "error", err)
+		watcher.polling = true
+		watcher.close = func() error { return nil }
+		return watcher
+	}
+	watcher.close = closeFunc
+	return watcher
+}
+
+// signals that something changed in the watched directory, events are coalesced if nobody is waiting
+func (watcher *DirWatcher) notify() {
+	select {
+	case watcher.events <- struct{}{}:
+	default:
+	}
+}
+
+// blocks until the watched directory changed or the timeout elapsed, returns false if stop was closed
+func (watcher *DirWatcher) wait(stop <-chan struct{}) bool {
+	timeout := dirWatchTimeout
+	if watcher.polling {
+		timeout = dirPollInterval
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-watcher.events:
+	case <-timer.C:
+	case <-stop:
+		return false
+	}
+	return true
+}
+
+func (watcher *DirWatcher) Close() error {
+	return watcher.close()
+}
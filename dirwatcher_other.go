@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// there is no native watcher on this platform, the directory watcher falls back to polling
+func watchDirectory(dir string, notify func()) (func() error, error) {
+	return nil, errors.New("directory watching is not supported on this platform")
+}
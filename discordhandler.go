@@ -3,89 +3,126 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/bwmarrin/discordgo"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	botID          string
-	session        *discordgo.Session
-	commandPattern *regexp.Regexp
+	botID   string
+	discord DiscordSession
+	// replaced when the config is reloaded
+	commandPattern atomic.Pointer[regexp.Regexp]
 )
 
 type ResponseHandler struct {
 	respond        func(string)
-	session        *discordgo.Session
-	message        *discordgo.MessageCreate
+	respondEmbed   func(*discordgo.MessageEmbed)
+	respondFile    func(string, *discordgo.File)
+	session        DiscordSession
+	channelID      string
 	guild          *discordgo.Guild
 	author         *discordgo.Member
+	mentions       []*discordgo.User
 	messageContent []string
-}
-
-func init() {
-	commandPattern, _ = regexp.Compile(`^!(\w+)(\s|$)`)
+	// the options of a slash command by name, nil for a text command
+	options map[string]string
+	// the server linked to the channel, set before a command is handled
+	server *Server
 }
 
 func startDiscordBot() {
 
-	var err error
-	session, err = discordgo.New("Bot " + Config.Discord.Token)
+	logger := subsystemLogger("discord")
+	session, err := discordgo.New("Bot " + Config().Discord.Token)
 	if err != nil {
-		log.Println("error creating Discord session,", err)
+		logger.Error("Could not create Discord session", "error", err)
 		return
 	}
 
 	// Get the account information.
 	user, err := session.User("@me")
 	if err != nil {
-		log.Println("error obtaining account details,", err)
+		logger.Error("Could not obtain account details", "error", err)
 	}
 	botID = user.ID
+	discord = gatewaySession{session}
 
 	session.UpdateGameStatus(0, "")
 	session.AddHandler(chatEventHandler)
+	session.AddHandler(trackChannelMessages)
+	session.AddHandler(discordConnectHandler)
+	session.AddHandler(discordDisconnectHandler)
+	if Config().slashCommandsEnabled() {
+		session.AddHandler(guildCreateEventHandler)
+		session.AddHandler(interactionEventHandler)
+	}
+	updateCommandPattern()
 
 	session.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAll)
 
 	// open the websocket and begin listening.
 	err = session.Open()
 	if err != nil {
-		log.Println("error opening connection,", err)
+		logger.Error("Could not open connection", "error", err)
 		return
 	}
 
-	log.Println("Discord Bot is now running.")
+	logger.Info("Discord Bot is now running")
 }
 
-func createResponseHandler(s *discordgo.Session, m *discordgo.MessageCreate, message []string) *ResponseHandler {
-	guild, err := getGuildForChannel(s, m.ChannelID)
-	if err != nil {
-		panic(err.Error())
+// builds the pattern for the text commands from the configured prefix
+func updateCommandPattern() {
+	if prefix := Config().commandPrefix(); prefix != "" {
+		commandPattern.Store(regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `(\w+)(\s|$)`))
+	} else {
+		commandPattern.Store(nil)
 	}
-	author, _ := s.State.Member(guild.ID, m.Author.ID)
+}
+
+func createResponseHandler(m *discordgo.MessageCreate, guild *discordgo.Guild, message []string) *ResponseHandler {
+	author, _ := discord.Member(guild.ID, m.Author.ID)
 	return &ResponseHandler{
 		func(text string) {
-			_, _ = s.ChannelMessageSend(m.ChannelID, text)
+			_, _ = discord.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: text})
+		},
+		func(embed *discordgo.MessageEmbed) {
+			_, _ = discord.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}})
 		},
-		s,
-		m,
+		func(text string, file *discordgo.File) {
+			_, _ = discord.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: text, Files: []*discordgo.File{file}})
+		},
+		discord,
+		m.ChannelID,
 		guild,
 		author,
+		m.Mentions,
 		message,
+		nil,
+		nil,
 	}
 }
 
-func getGuildForChannel(s *discordgo.Session, channelID string) (*discordgo.Guild, error) {
-	for _, guild := range s.State.Guilds {
-		channels, _ := s.GuildChannels(guild.ID)
+// the text of an argument: the option with the given name of a slash command, or everything after a text command
+func (r *ResponseHandler) argumentText(name string) string {
+	if r.options != nil {
+		return r.options[name]
+	}
+	return strings.Join(r.messageContent, " ")
+}
+
+func getGuildForChannel(channelID string) (*discordgo.Guild, error) {
+	if channel, err := discord.Channel(channelID); err == nil && channel.GuildID != "" {
+		if guild, err := discord.Guild(channel.GuildID); err == nil {
+			return guild, nil
+		}
+	}
+	for _, guild := range discord.Guilds() {
+		channels, _ := discord.GuildChannels(guild.ID)
 		for _, channel := range channels {
 			if channel.ID == channelID {
 				return guild, nil
@@ -96,7 +133,7 @@ func getGuildForChannel(s *discordgo.Session, channelID string) (*discordgo.Guil
 }
 
 func getUserNickname(user *discordgo.User, guild *discordgo.Guild) string {
-	if member, err := session.State.Member(guild.ID, user.ID); err == nil {
+	if member, err := discord.Member(guild.ID, user.ID); err == nil {
 		return getMemberNickname(member)
 	}
 	return user.Username
@@ -110,24 +147,32 @@ func getMemberNickname(member *discordgo.Member) string {
 }
 
 func chatEventHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	handleDiscordMessage(m)
+}
 
-	// ignore all messages created by the bot itself
+// forwards a message from Discord to the linked game server, or runs the command it contains
+func handleDiscordMessage(m *discordgo.MessageCreate) {
+	// ignore all messages created by the bot itself, including the chat messages it posts through webhooks
 	author := m.Author
-	if author.ID == botID {
+	if author.ID == botID || m.WebhookID != "" {
 		return
 	}
 
-	guild, err := getGuildForChannel(s, m.ChannelID)
+	guild, err := getGuildForChannel(m.ChannelID)
 	if err != nil {
-		panic(err.Error())
+		// direct messages, i.e. replies to the code of !link, have no guild and are not handled
+		return
 	}
-	authorMember, err := s.State.Member(guild.ID, author.ID)
+	authorMember, err := discord.Member(guild.ID, author.ID)
 	if err != nil {
 		// ignore non-member messages
 		return
 	}
 
-	commandMatches := commandPattern.FindStringSubmatch(m.Content)
+	var commandMatches []string
+	if pattern := commandPattern.Load(); pattern != nil {
+		commandMatches = pattern.FindStringSubmatch(m.Content)
+	}
 
 	if len(commandMatches) == 0 { // this is a regular message
 		server, isServerLinked := serverList.getServerByChannelID(m.ChannelID)
@@ -140,61 +185,40 @@ func chatEventHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 			return
 		}
 		nick := sanitizeForGame(getMemberNickname(authorMember))
-		v := url.Values{}
-		v.Set("request", "discordsend")
-		v.Set("user", nick)
-		v.Set("msg", formatDiscordMessage(m))
-		_, err := http.PostForm(server.Config.WebAdmin, v)
-
-		if err != nil {
-			log.Println(err.Error())
+		message := formatDiscordMessage(m, guild)
+		messageArchive.record(ArchiveRecord{Server: server.Name, Kind: "discord", Player: nick, DiscordID: author.ID, Message: message})
+		if server.usesOutboundQueue() {
+			server.Outbound.push("chat", nick, message)
+			return
+		}
+		if err := server.WebAdmin.sendChat(nick, message); err != nil {
+			serverLogger("webadmin", server.Name).Error("Could not forward message to web admin", "error", err)
+			if server.WebAdmin.shouldNotify() {
+				queueDiscordText(m.ChannelID, webAdminErrorMessage(server.Name, err)+", messages are not delivered to the game", false)
+			}
 		}
-
 		return
 	}
 
 	// message was a discord command
 	messageFields := strings.Fields(m.Content)[1:]
-	responseHandler := createResponseHandler(s, m, messageFields)
-
-	// first handle the commands that don't require a linked server
-	switch commandMatches[1] {
-	case "mute":
-		responseHandler.muteUser()
-	case "unmute":
-		responseHandler.unmuteUser()
-	case "rcon":
-		responseHandler.sendRconCommand()
-	case "info":
-		responseHandler.requestServerInfo()
-	case "status":
-		responseHandler.requestServerStatus()
-	case "channelinfo":
-		responseHandler.printChannelInfo()
-	case "version":
-		responseHandler.printVersion()
-	default:
-		fallthrough
-	case "commands":
-		fallthrough
-	case "help":
-		responseHandler.printHelpMessage()
-	}
+	responseHandler := createResponseHandler(m, guild, messageFields)
+	commandRegistry.dispatch(commandMatches[1], responseHandler)
 }
 
-func (r *ResponseHandler) printChannelInfo() {
+func (r *ResponseHandler) printChannelInfo() error {
 	response := make([]string, 6)
 	response = append(response, "```")
-	channel, _ := r.session.State.Channel(r.message.ChannelID)
+	channel, _ := r.session.Channel(r.channelID)
 	response = append(response, "Channel '"+channel.Name+"' Id: "+channel.ID)
 	guild, _ := r.session.Guild(channel.GuildID)
 	response = append(response, "Guild '"+guild.Name+"' Id: "+guild.ID)
 	for _, role := range guild.Roles {
 		response = append(response, "Role '"+role.Name+"' Id: "+role.ID)
 	}
-	for _, server := range serverList {
-		id := server.Config.ChannelID
-		linkedChannel, err := r.session.State.Channel(id)
+	for _, server := range serverList.all() {
+		id := server.Config().ChannelID
+		linkedChannel, err := r.session.Channel(id)
 		name := "<unknown channel>"
 		if err == nil {
 			name = "<#" + linkedChannel.Name + ">"
@@ -203,135 +227,167 @@ func (r *ResponseHandler) printChannelInfo() {
 	}
 	response = append(response, "```")
 	r.respond(strings.Join(response, "\n"))
+	return nil
 }
 
-func (r *ResponseHandler) printVersion() {
+func (r *ResponseHandler) printVersion() error {
 	r.respond("Version " + version)
+	return nil
 }
 
-func (r *ResponseHandler) muteUser() {
-	server, isServerLinked := serverList.getServerByChannelID(r.message.ChannelID)
-	if !isServerLinked {
-		r.respond("Channel is not linked to any server.")
-		return
+// parses the arguments of the mute command: mentions, followed by an optional duration and an optional reason
+func parseMuteArguments(fields []string) (duration time.Duration, reason string) {
+	rest := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !mentionPattern.MatchString(field) {
+			rest = append(rest, field)
+		}
 	}
-
-	if !server.isAdmin(r.author) {
-		r.respond("You are not registered as an admin for server '" + server.Name + "'")
-		return
+	if len(rest) > 0 {
+		if d, ok := parseMuteDuration(rest[0]); ok {
+			duration = d
+			rest = rest[1:]
+		}
 	}
+	return duration, strings.Join(rest, " ")
+}
 
-	count := 0
-	for _, mention := range r.message.Mentions {
-		mentionedMember, err := r.session.State.Member(r.guild.ID, mention.ID)
-		if err == nil && !server.isMuted(mentionedMember) {
-			server.Muted = append(server.Muted, DiscordIdentity(mention.ID))
-			count++
+// the duration and reason of a mute, a slash command has them as separate options
+func (r *ResponseHandler) muteArguments() (time.Duration, string, error) {
+	if r.options == nil {
+		duration, reason := parseMuteArguments(r.messageContent)
+		return duration, reason, nil
+	}
+	var duration time.Duration
+	if text := strings.TrimSpace(r.options["duration"]); text != "" {
+		var ok bool
+		if duration, ok = parseMuteDuration(text); !ok {
+			return 0, "", errors.New("Invalid duration '" + text + "', use i.e. 30m, 2h or 7d")
 		}
 	}
-	r.respond("Muted " + strconv.Itoa(count) + " users")
+	return duration, strings.TrimSpace(r.options["reason"]), nil
 }
 
-func (r *ResponseHandler) unmuteUser() {
-	server, isServerLinked := serverList.getServerByChannelID(r.message.ChannelID)
-	if !isServerLinked {
-		r.respond("Channel is not linked to any server.")
-		return
+func (r *ResponseHandler) muteUser() error {
+	duration, reason, err := r.muteArguments()
+	if err != nil {
+		return err
 	}
-
-	if !server.isAdmin(r.author) {
-		r.respond("You are not registered as an admin for server '" + server.Name + "'")
-		return
+	arguments := reason
+	if duration > 0 {
+		arguments = strings.TrimSpace(formatDuration(duration) + " " + reason)
+	}
+	now := time.Now()
+	count := 0
+	for _, mention := range r.mentions {
+		mentionedMember, err := r.session.Member(r.guild.ID, mention.ID)
+		if err != nil {
+			entry := r.auditEntry("mute", arguments, "failed: not a member of the guild")
+			entry.Target = auditTarget(mention.Username, mention.ID)
+			auditLog.record(entry)
+			continue
+		}
+		mute := Mute{
+			UserID:    mention.ID,
+			UserName:  getMemberNickname(mentionedMember),
+			MutedBy:   getMemberNickname(r.author),
+			MutedByID: r.author.User.ID,
+			MutedAt:   now,
+			Reason:    reason,
+		}
+		if duration > 0 {
+			mute.Expires = now.Add(duration)
+		}
+		muteStore.add(r.server.Name, mute)
+		count++
+		serverLogger("mutes", r.server.Name).Info("Muted user", "user", mentionedMember.User.Username+"#"+mentionedMember.User.Discriminator, "user_id", mention.ID, "duration", duration, "reason", reason)
+		entry := r.auditEntry("mute", arguments, "muted")
+		entry.Target = auditTarget(mute.UserName, mention.ID)
+		auditLog.record(entry)
+	}
+	response := "Muted " + strconv.Itoa(count) + " user(s)"
+	if duration > 0 {
+		response += " for " + formatDuration(duration)
 	}
+	r.respond(response)
+	return nil
+}
 
+func (r *ResponseHandler) unmuteUser() error {
+	server := r.server
 	count := 0
-	for _, mentionedUser := range r.message.Mentions {
-		for i, mutedUser := range server.Muted {
-			if mutedUser.matchesUser(mentionedUser) {
-				server.Muted = append(server.Muted[:i], server.Muted[i+1:]...)
-				count++
-				log.Println("Muted user", "'"+mentionedUser.Username+"#"+mentionedUser.Discriminator+"'", "id:", mentionedUser.ID)
-			}
+	for _, mentionedUser := range r.mentions {
+		unmuted := muteStore.remove(server.Name, mentionedUser.ID)
+		// users muted in the config are only unmuted until the next restart
+		if server.unmuteConfigUser(mentionedUser) {
+			unmuted = true
+		}
+		entry := r.auditEntry("unmute", "", "was not muted")
+		entry.Target = auditTarget(mentionedUser.Username, mentionedUser.ID)
+		if unmuted {
+			count++
+			serverLogger("mutes", server.Name).Info("Unmuted user", "user", mentionedUser.Username+"#"+mentionedUser.Discriminator, "user_id", mentionedUser.ID)
+			entry.Outcome = "unmuted"
 		}
+		auditLog.record(entry)
 	}
 	r.respond("Unmuted " + strconv.Itoa(count) + " user(s)")
+	return nil
 }
 
-func (r *ResponseHandler) requestServerStatus() {
-	server, isServerLinked := serverList.getServerByChannelID(r.message.ChannelID)
-	if !isServerLinked {
-		r.respond("Channel is not linked to any server.")
-		return
+func (r *ResponseHandler) listMutes() error {
+	mutes := muteStore.list(r.server.Name)
+	configMutes := r.server.configMutes()
+	if len(mutes) == 0 && len(configMutes) == 0 {
+		r.respond("Nobody is muted on server '" + r.server.Name + "'")
+		return nil
 	}
 
-	resp, err := http.PostForm(server.Config.WebAdmin, url.Values{
-		"request": {"discordinfo"},
-	})
-
-	if err != nil {
-		log.Println(err.Error())
+	now := time.Now()
+	response := []string{"Muted users on server '" + r.server.Name + "':", "```"}
+	for _, mute := range mutes {
+		line := mute.UserName + " (" + mute.UserID + ") - muted by " + mute.MutedBy + " " + formatDuration(now.Sub(mute.MutedAt)) + " ago"
+		if mute.Expires.IsZero() {
+			line += ", does not expire"
+		} else {
+			line += ", expires in " + formatDuration(mute.Expires.Sub(now))
+		}
+		if mute.Reason != "" {
+			line += " - " + mute.Reason
+		}
+		response = append(response, line)
 	}
-
-	serverInfo := ServerInfo{}
-	body, _ := ioutil.ReadAll(resp.Body)
-	_ = json.Unmarshal(body, &serverInfo)
-	forwardServerStatusToDiscord(server, MessageType{GroupType: "info", SubType: "status"}, serverInfo)
-}
-
-func (r *ResponseHandler) requestServerInfo() {
-	server, isServerLinked := serverList.getServerByChannelID(r.message.ChannelID)
-	if !isServerLinked {
-		r.respond("Channel is not linked to any server.")
-		return
+	for _, identity := range configMutes {
+		response = append(response, identity.String()+" - muted in the config")
 	}
+	response = append(response, "```")
+	r.respond(strings.Join(response, "\n"))
+	return nil
+}
 
-	resp, err := http.PostForm(server.Config.WebAdmin, url.Values{
-		"request": {"discordinfo"},
-	})
-
+func (r *ResponseHandler) requestServerStatus() error {
+	serverInfo, err := r.server.WebAdmin.serverInfo()
 	if err != nil {
-		log.Println(err.Error())
+		serverLogger("webadmin", r.server.Name).Error("Could not get the server status", "error", err)
+		r.respond(webAdminErrorMessage(r.server.Name, err))
+		return nil
 	}
-
-	serverInfo := ServerInfo{}
-	body, _ := ioutil.ReadAll(resp.Body)
-	_ = json.Unmarshal(body, &serverInfo)
-	forwardServerStatusToDiscord(server, MessageType{GroupType: "info", SubType: "info"}, serverInfo)
+	r.respondEmbed(buildServerStatusEmbed(r.server, MessageType{GroupType: "info", SubType: "status"}, serverInfo))
+	return nil
 }
 
-func (r *ResponseHandler) sendRconCommand() {
-	server, isServerLinked := serverList.getServerByChannelID(r.message.ChannelID)
-	if !isServerLinked {
-		r.respond("Channel is not linked to any server.")
-		return
-	}
-
-	if !server.isAdmin(r.author) {
-		r.respond("You are not registered as an admin for server '" + server.Name + "'")
-		return
-	}
-
-	_, err := http.PostForm(server.Config.WebAdmin, url.Values{
-		"rcon": {strings.Join(r.messageContent[:], " ")},
-	})
-
+func (r *ResponseHandler) requestServerInfo() error {
+	serverInfo, err := r.server.WebAdmin.serverInfo()
 	if err != nil {
-		log.Println(err.Error())
+		serverLogger("webadmin", r.server.Name).Error("Could not get the server info", "error", err)
+		r.respond(webAdminErrorMessage(r.server.Name, err))
+		return nil
 	}
+	r.respondEmbed(buildServerStatusEmbed(r.server, MessageType{GroupType: "info", SubType: "info"}, serverInfo))
+	return nil
 }
 
-func (r *ResponseHandler) printHelpMessage() {
-	r.respond("```" + `
-!help					 - prints this help
-!commands				 - prints this help
-!status					 - prints a short server status
-!info					 - prints a long server info
-!channelinfo			 - prints ids of the current channel, guild and roles
-!version				 - prints the version number
-
-admin commands:
-!mute @discorduser(s)	 - dont forward messages from user(s) to the server
-!unmute @discorduser(s)  - remove user(s) from being muted
-!rcon <console commands> - executes console commands directly on the linked server
-` + "```")
+func (r *ResponseHandler) printHelpMessage() error {
+	r.respond(commandRegistry.helpMessage())
+	return nil
 }
@@ -43,20 +43,67 @@ type ServerInfoModInfo struct {
 	Name string `json:"name"`
 }
 
-var (
-	DefaultMessageColor      int = 75*256*256 + 78*256 + 82
-	lastMultilineChatMessage *discordgo.Message
-)
+var DefaultMessageColor int = 75*256*256 + 78*256 + 82
+
+func init() {
+	eventBus.subscribe(forwardLogEventToDiscord)
+}
+
+// forwards the events of a game server to its linked Discord channel
+func forwardLogEventToDiscord(server *Server, event LogEvent) {
+	switch event := event.(type) {
+	case ChatEvent:
+		// link codes are consumed, so nobody else can see them
+		if accountLinks.confirm(server, event) {
+			return
+		}
+		accountLinks.seen(event.SteamID, event.Name)
+		forwardChatMessageToDiscord(server, event.SteamID.displayName(event.Name), event.SteamID, event.Team, event.Message)
+	case PlayerEvent:
+		accountLinks.seen(event.SteamID, event.Name)
+		msgtype := MessageType{
+			GroupType: "player",
+			SubType:   event.Action,
+		}
+		forwardPlayerEventToDiscord(server, msgtype, event.Name, event.SteamID, event.PlayerCount)
+	case StatusEvent:
+		var message string
+		msgtype := MessageType{GroupType: "status"}
+		switch event.State {
+		case "Started":
+			message = "Round started on "
+			msgtype.SubType = "roundstart"
+		case "Team1Won":
+			message = "Marines won on "
+			msgtype.SubType = "marinewin"
+		case "Team2Won":
+			message = "Aliens won on "
+			msgtype.SubType = "alienwin"
+		case "Draw":
+			message = "Draw on "
+			msgtype.SubType = "draw"
+		default:
+			return
+		}
+		forwardStatusMessageToDiscord(server, msgtype, message, event.PlayerCount, event.Map)
+	case ChangeMapEvent:
+		forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "changemap"}, "Changing map to ", event.PlayerCount, event.Map)
+	case InitEvent:
+		forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "init"}, "Loaded ", "", event.Map)
+	case AdminPrintEvent:
+		forwardStatusMessageToDiscord(server, MessageType{GroupType: "adminprint"}, event.Message, "", "")
+	}
+}
 
 /* gets the guild icon for the supplied server
  * used for status messages
  */
 func (messagetype MessageType) getIcon(server *Server) string {
-	configuredIcon := server.Config.ServerIconUrl
-	if configuredIcon != "" || server.Config.ChannelID == "" {
+	configuredIcon := server.Config().ServerIconUrl
+	if configuredIcon != "" || server.Config().ChannelID == "" {
 		return configuredIcon
 	}
-	guild, err := getGuildForChannel(session, server.Config.ChannelID)
+	guild, err := getGuildForChannel(server.Config().ChannelID)
 	if err == nil {
 		return "https://cdn.discordapp.com/icons/" + guild.ID + "/" + guild.Icon + ".png"
 	}
@@ -66,24 +113,24 @@ func (messagetype MessageType) getIcon(server *Server) string {
 /* decides which color a message should get (rich message style only)
  * based on the message type
  */
-func (messagetype MessageType) getColor() int {
-	msgConfig := Config.MessageStyles.Rich
+func (messagetype MessageType) getColor(server *Server) int {
+	msgConfig := server.richStyle()
 	switch messagetype.GroupType {
 	case "player":
 		switch messagetype.SubType {
 		case "join":
-			return Config.getColor(msgConfig.PlayerJoinColor, DefaultMessageColor)
+			return Config().getColor(msgConfig.PlayerJoinColor, DefaultMessageColor)
 		case "leave":
-			return Config.getColor(msgConfig.PlayerLeaveColor, DefaultMessageColor)
+			return Config().getColor(msgConfig.PlayerLeaveColor, DefaultMessageColor)
 		default:
-			return Config.getColor(msgConfig.StatusColor, DefaultMessageColor)
+			return Config().getColor(msgConfig.StatusColor, DefaultMessageColor)
 		}
 	case "info":
 		fallthrough
 	case "status":
 		fallthrough
 	case "adminprint":
-		return Config.getColor(msgConfig.StatusColor, DefaultMessageColor)
+		return Config().getColor(msgConfig.StatusColor, DefaultMessageColor)
 	default:
 		return DefaultMessageColor
 	}
@@ -92,24 +139,24 @@ func (messagetype MessageType) getColor() int {
 /* decides which color a message should get (rich message style only)
  * based on the team type
  */
-func (teamNumber TeamNumber) getColor() int {
-	msgConfig := Config.MessageStyles.Rich
+func (teamNumber TeamNumber) getColor(server *Server) int {
+	msgConfig := server.richStyle()
 	switch teamNumber {
 	default:
 		fallthrough
 	case 0:
-		return Config.getColor(msgConfig.ChatMessageReadyRoomColor, DefaultMessageColor)
+		return Config().getColor(msgConfig.ChatMessageReadyRoomColor, DefaultMessageColor)
 	case 1:
-		return Config.getColor(msgConfig.ChatMessageMarineColor, DefaultMessageColor)
+		return Config().getColor(msgConfig.ChatMessageMarineColor, DefaultMessageColor)
 	case 2:
-		return Config.getColor(msgConfig.ChatMessageAlienColor, DefaultMessageColor)
+		return Config().getColor(msgConfig.ChatMessageAlienColor, DefaultMessageColor)
 	case 3:
-		return Config.getColor(msgConfig.ChatMessageSpectatorColor, DefaultMessageColor)
+		return Config().getColor(msgConfig.ChatMessageSpectatorColor, DefaultMessageColor)
 	}
 }
 
-func (teamNumber TeamNumber) getPrefix() string {
-	msgConfig := Config.MessageStyles.Text
+func (teamNumber TeamNumber) getPrefix(server *Server) string {
+	msgConfig := server.textStyle()
 	switch teamNumber {
 	case 0:
 		return msgConfig.ChatMessageReadyRoomPrefix
@@ -224,16 +271,16 @@ func sanitizePlayerNames(players []string) []string {
 }
 
 func buildTextChatMessage(server *Server, username string, teamNumber TeamNumber, message string) string {
-	messageFormat := Config.MessageStyles.Text.ChatMessageFormat
-	teamSpecificString := teamNumber.getPrefix()
-	serverSpecificString := server.Config.ServerChatMessagePrefix
+	messageFormat := server.textStyle().ChatMessageFormat
+	teamSpecificString := teamNumber.getPrefix(server)
+	serverSpecificString := server.Config().ServerChatMessagePrefix
 	replacer := strings.NewReplacer("%p", username, "%m", message, "%t", teamSpecificString, "%s", serverSpecificString)
 	formattedMessage := replacer.Replace(messageFormat)
 	return formattedMessage
 }
 
 func buildTextPlayerEvent(server *Server, messagetype MessageType, username string, message string) string {
-	messageConfig := Config.MessageStyles.Text
+	messageConfig := server.textStyle()
 	messageFormat := "%s %p %m"
 	switch messagetype.SubType {
 	case "join":
@@ -241,29 +288,21 @@ func buildTextPlayerEvent(server *Server, messagetype MessageType, username stri
 	case "leave":
 		messageFormat = messageConfig.PlayerLeaveFormat
 	}
-	serverSpecificString := server.Config.ServerChatMessagePrefix
+	serverSpecificString := server.Config().ServerChatMessagePrefix
 	replacer := strings.NewReplacer("%p", username, "%m", message, "%s", serverSpecificString)
 	formattedMessage := replacer.Replace(messageFormat)
 	return formattedMessage
 }
 
-func getLastMessageID(channelID string) (string, bool) {
-	messages, _ := session.ChannelMessages(channelID, 1, "", "", "")
-	if len(messages) == 1 {
-		return messages[0].ID, true
-	}
-	return "", false
-}
-
 func findKeywordNotifications(server *Server, message string) (found bool, response string) {
-	guild, err := getGuildForChannel(session, server.Config.ChannelID)
+	guild, err := getGuildForChannel(server.Config().ChannelID)
 	if err != nil {
 		return false, ""
 	}
 
 	fields := strings.Fields(message)
-	keywordMapping := server.Config.KeywordNotifications
-	for i := 0; i < len(keywordMapping); i += 2 {
+	keywordMapping := server.Config().KeywordNotifications
+	for i := 0; i+1 < len(keywordMapping); i += 2 {
 		keywords := keywordMapping[i]
 		mentions := keywordMapping[i+1]
 		for _, keyword := range keywords {
@@ -280,7 +319,7 @@ func findKeywordNotifications(server *Server, message string) (found bool, respo
 
 func triggerKeywords(server *Server, message string) {
 	if keywordsFound, mentions := findKeywordNotifications(server, message); keywordsFound && mentions != "" {
-		_, _ = session.ChannelMessageSend(server.Config.ChannelID, mentions)
+		queueDiscordText(server.Config().ChannelID, mentions, false)
 	}
 }
 
@@ -291,48 +330,49 @@ func forwardChatMessageToDiscord(server *Server, username string, steamID SteamI
 	// Enforce Discord's embed description limit (4096 characters)
 	translatedMessage = truncateUTF8(translatedMessage, 4096)
 	sanitizedUsername := sanitizeUsername(username)
-	switch Config.Discord.MessageStyle {
+	messageStyle := server.messageStyle()
+	if messageStyle == "webhook" {
+		if webhook := channelWebhooks.get(server.Config().ChannelID); webhook != nil {
+			queueDiscordMessage(server.Config().ChannelID, &DiscordMessage{
+				Content:   truncateUTF8(translatedMessage, maxMessageLength),
+				Webhook:   webhook,
+				Username:  webhookUsername(sanitizedUsername),
+				AvatarURL: steamID.getAvatar(),
+			})
+			triggerKeywords(server, translatedMessage)
+			return
+		}
+		messageStyle = "multiline"
+	}
+	switch messageStyle {
 	default:
 		fallthrough
 	case "multiline":
-		lastMessageID, ok := getLastMessageID(server.Config.ChannelID)
-		if ok && lastMultilineChatMessage != nil {
-			lastEmbed := lastMultilineChatMessage.Embeds[0]
-			lastAuthor := lastEmbed.Author
-			if lastMessageID == lastMultilineChatMessage.ID &&
-				lastEmbed.Color == teamNumber.getColor() &&
-				lastAuthor.Name == sanitizedUsername &&
-				lastAuthor.URL == steamID.getSteamProfileLink() {
-				// append to last message
-				lastEmbed.Description += "\n" + translatedMessage
-				lastMultilineChatMessage, _ = session.ChannelMessageEditEmbed(server.Config.ChannelID, lastMessageID, lastEmbed)
-				triggerKeywords(server, translatedMessage)
-				return
-			}
-		}
 		embed := &discordgo.MessageEmbed{
 			Description: translatedMessage,
-			Color:       teamNumber.getColor(),
+			Color:       teamNumber.getColor(server),
 			Author: &discordgo.MessageEmbedAuthor{
 				URL:     steamID.getSteamProfileLink(),
 				Name:    sanitizedUsername,
 				IconURL: steamID.getAvatar(),
 			},
 		}
-		lastMultilineChatMessage, _ = session.ChannelMessageSendEmbed(server.Config.ChannelID, embed)
+		// consecutive messages of the same player in the same team are grouped into one message
+		group := strconv.Itoa(int(steamID)) + "/" + strconv.Itoa(embed.Color) + "/" + sanitizedUsername
+		queueDiscordMessage(server.Config().ChannelID, &DiscordMessage{Embed: embed, Group: group})
 
 	case "oneline":
 		embed := &discordgo.MessageEmbed{
-			Color: teamNumber.getColor(),
+			Color: teamNumber.getColor(server),
 			Footer: &discordgo.MessageEmbedFooter{
 				Text:    sanitizedUsername + ": " + translatedMessage,
 				IconURL: steamID.getAvatar(),
 			},
 		}
-		_, _ = session.ChannelMessageSendEmbed(server.Config.ChannelID, embed)
+		queueDiscordEmbed(server.Config().ChannelID, embed, false)
 
 	case "text":
-		_, _ = session.ChannelMessageSend(server.Config.ChannelID, buildTextChatMessage(server, sanitizedUsername, teamNumber, translatedMessage))
+		queueDiscordText(server.Config().ChannelID, buildTextChatMessage(server, sanitizedUsername, teamNumber, translatedMessage), false)
 	}
 
 	triggerKeywords(server, translatedMessage)
@@ -363,7 +403,7 @@ func forwardPlayerEventToDiscord(server *Server, messagetype MessageType, userna
 	// Discord footer text has a 2048 character limit
 	eventText = truncateUTF8(eventText, 2048)
 
-	switch Config.Discord.MessageStyle {
+	switch server.messageStyle() {
 	default:
 		fallthrough
 	case "multiline":
@@ -371,16 +411,16 @@ func forwardPlayerEventToDiscord(server *Server, messagetype MessageType, userna
 	case "oneline":
 		embed := &discordgo.MessageEmbed{
 			Timestamp: timestamp,
-			Color:     messagetype.getColor(),
+			Color:     messagetype.getColor(server),
 			Footer: &discordgo.MessageEmbedFooter{
 				Text:    eventText,
 				IconURL: steamID.getAvatar(),
 			},
 		}
-		_, _ = session.ChannelMessageSendEmbed(server.Config.ChannelID, embed)
+		queueDiscordEmbed(server.Config().ChannelID, embed, true)
 
 	case "text":
-		_, _ = session.ChannelMessageSend(server.Config.ChannelID, buildTextPlayerEvent(server, messagetype, sanitizedUsername, playerCount))
+		queueDiscordText(server.Config().ChannelID, buildTextPlayerEvent(server, messagetype, sanitizedUsername, playerCount), true)
 	}
 }
 
@@ -398,9 +438,9 @@ func forwardStatusMessageToDiscord(server *Server, messagetype MessageType, mess
 	// Discord footer text has a 2048 character limit
 	message = truncateUTF8(message, 2048)
 
-	statusChannelID := server.Config.StatusChannelID
+	statusChannelID := server.Config().StatusChannelID
 
-	switch Config.Discord.MessageStyle {
+	switch server.messageStyle() {
 	default:
 		fallthrough
 	case "multiline":
@@ -419,37 +459,41 @@ func forwardStatusMessageToDiscord(server *Server, messagetype MessageType, mess
 		}
 		embed := &discordgo.MessageEmbed{
 			Timestamp: timestamp,
-			Color:     messagetype.getColor(),
+			Color:     messagetype.getColor(server),
 			Footer: &discordgo.MessageEmbedFooter{
 				Text:    message,
 				IconURL: messagetype.getIcon(server),
 			},
 		}
-		_, _ = session.ChannelMessageSendEmbed(server.Config.ChannelID, embed)
+		queueDiscordEmbed(server.Config().ChannelID, embed, true)
 
-		if statusChannelID != "" && statusChannelID != server.Config.ChannelID {
-			_, _ = session.ChannelMessageSendEmbed(statusChannelID, embed)
+		if statusChannelID != "" && statusChannelID != server.Config().ChannelID {
+			queueDiscordEmbed(statusChannelID, embed, true)
 		}
 
 	case "text":
-		_, _ = session.ChannelMessageSend(server.Config.ChannelID, server.Config.ServerStatusMessagePrefix+message)
+		queueDiscordText(server.Config().ChannelID, server.Config().ServerStatusMessagePrefix+message, true)
 
-		if statusChannelID != "" && statusChannelID != server.Config.ChannelID {
-			_, _ = session.ChannelMessageSend(statusChannelID, server.Config.ServerStatusMessagePrefix+message)
+		if statusChannelID != "" && statusChannelID != server.Config().ChannelID {
+			queueDiscordText(statusChannelID, server.Config().ServerStatusMessagePrefix+message, true)
 		}
 	}
 
 	if messagetype.SubType == "changemap" {
-		if len(serverList) == 1 {
-			session.UpdateGameStatus(0, mapname)
+		if serverList.count() == 1 {
+			discord.UpdateGameStatus(0, mapname)
 			// session.UpdateStreamingStatus(0, "Natural Selection 2", "https://www.twitch.tv/naturalselection2")
 		} else {
-			session.UpdateGameStatus(0, "")
+			discord.UpdateGameStatus(0, "")
 		}
 	}
 }
 
 func forwardServerStatusToDiscord(server *Server, messagetype MessageType, info ServerInfo) {
+	queueDiscordEmbed(server.Config().ChannelID, buildServerStatusEmbed(server, messagetype, info), false)
+}
+
+func buildServerStatusEmbed(server *Server, messagetype MessageType, info ServerInfo) *discordgo.MessageEmbed {
 	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05")
 	gameTimeSec, _ := math.Modf(info.GameTime)
 	
@@ -541,7 +585,7 @@ func forwardServerStatusToDiscord(server *Server, messagetype MessageType, info
 	serverIpPort = truncateUTF8(serverIpPort, 2048)
 
 	embed := &discordgo.MessageEmbed{
-		Color: messagetype.getColor(),
+		Color: messagetype.getColor(server),
 		Author: &discordgo.MessageEmbedAuthor{
 			Name:    serverName,
 			IconURL: messagetype.getIcon(server),
@@ -549,9 +593,10 @@ func forwardServerStatusToDiscord(server *Server, messagetype MessageType, info
 		Description: description,
 		Fields:      fields,
 		Timestamp:   timestamp,
-		Footer: &discordgo.MessageEmbedFooter{
-			Text: serverIpPort,
-		},
 	}
-	_, _ = session.ChannelMessageSendEmbed(server.Config.ChannelID, embed)
+	// the address is unknown if the info only comes from the events (status board without web admin)
+	if info.ServerIp != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: serverIpPort}
+	}
+	return embed
 }
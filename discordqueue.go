@@ -0,0 +1,375 @@
+// This file contains the queue of the messages that are sent to Discord.
+// Every channel has its own queue and worker, so messages keep their order, a rate limited channel doesn't hold up the others,
+// and the log parser never waits for Discord. The rate limit buckets themselves are tracked by discordgo,
+// the worker only waits when a request is rejected anyway and retries failures that are not the fault of the message.
+// The worker also groups the chat messages of the multiline style, as it knows what was sent to its channel last
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	maxQueuedDiscordMessages = 500
+	maxDiscordSendAttempts   = 6
+	discordRetryBaseDelay    = time.Second
+	discordRetryMaxDelay     = 30 * time.Second
+	// limits of a single Discord message
+	maxMessageLength          = 2000
+	maxEmbedsPerMessage       = 10
+	maxEmbedLengthPerPost     = 6000
+	maxEmbedDescriptionLength = 4096
+)
+
+type DiscordMessage struct {
+	Content string
+	Embed   *discordgo.MessageEmbed
+	// if set, the message with this id is edited instead of sending a new one
+	EditID string
+	// if set, the message is posted through the webhook with the given name and avatar
+	Webhook   *discordgo.Webhook
+	Username  string
+	AvatarURL string
+	// may be merged with the queued messages next to it when messages pile up, i.e. join/leave and status messages
+	Coalesce bool
+	/* the description of the embed is appended to the last message of the channel if it belongs to the same group,
+	 * is still the newest message in the channel, and is neither too old nor too long
+	 */
+	Group string
+	// called by the worker with the sent or edited message, or with the error if it could not be sent
+	OnSent func(message *discordgo.Message, err error)
+}
+
+type DiscordChannelQueue struct {
+	sync.Mutex
+	channelID string
+	pending   []*DiscordMessage
+	wake      chan struct{}
+	// the newest message in the channel, from the gateway or sent by the worker
+	newestID string
+	// the message that grouped messages are appended to, only used by the worker
+	group *MessageGroup
+}
+
+type MessageGroup struct {
+	key     string
+	message *discordgo.Message
+	embed   *discordgo.MessageEmbed
+	started time.Time
+}
+
+type DiscordQueues struct {
+	sync.Mutex
+	queues map[string]*DiscordChannelQueue
+}
+
+var discordQueues = &DiscordQueues{queues: make(map[string]*DiscordChannelQueue)}
+
+// queues a message for a channel, it is sent after all messages that were queued for the channel before
+func queueDiscordMessage(channelID string, message *DiscordMessage) {
+	if channelID == "" {
+		return
+	}
+	discordQueues.get(channelID).push(message)
+}
+
+func queueDiscordText(channelID string, content string, coalesce bool) {
+	queueDiscordMessage(channelID, &DiscordMessage{Content: content, Coalesce: coalesce})
+}
+
+func queueDiscordEmbed(channelID string, embed *discordgo.MessageEmbed, coalesce bool) {
+	queueDiscordMessage(channelID, &DiscordMessage{Embed: embed, Coalesce: coalesce})
+}
+
+// remembers the newest message of every channel with a queue, so grouping knows when someone else wrote in between
+func trackChannelMessages(s *discordgo.Session, m *discordgo.MessageCreate) {
+	discordQueues.Lock()
+	queue, ok := discordQueues.queues[m.ChannelID]
+	discordQueues.Unlock()
+	if ok {
+		queue.seen(m.ID)
+	}
+}
+
+// returns the queue of a channel, the worker is started with the first message
+func (queues *DiscordQueues) get(channelID string) *DiscordChannelQueue {
+	queues.Lock()
+	defer queues.Unlock()
+	queue, ok := queues.queues[channelID]
+	if !ok {
+		queue = &DiscordChannelQueue{
+			channelID: channelID,
+			wake:      make(chan struct{}, 1),
+		}
+		queues.queues[channelID] = queue
+		go queue.run()
+	}
+	return queue
+}
+
+func (queue *DiscordChannelQueue) push(message *DiscordMessage) {
+	queue.Lock()
+	queue.pending = append(queue.pending, message)
+	if len(queue.pending) > maxQueuedDiscordMessages {
+		dropped := len(queue.pending) - maxQueuedDiscordMessages
+		subsystemLogger("discordqueue").Warn("Queue is full, dropping messages", "channel_id", queue.channelID, "count", dropped)
+		queue.pending = queue.pending[dropped:]
+	}
+	queue.Unlock()
+
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *DiscordChannelQueue) seen(messageID string) {
+	queue.Lock()
+	defer queue.Unlock()
+	// snowflakes grow over time, so the longer or (with equal length) the greater one is newer
+	if len(messageID) > len(queue.newestID) || (len(messageID) == len(queue.newestID) && messageID > queue.newestID) {
+		queue.newestID = messageID
+	}
+}
+
+func (queue *DiscordChannelQueue) run() {
+	for {
+		batch := queue.next()
+		if batch == nil {
+			<-queue.wake
+			continue
+		}
+		queue.send(batch)
+	}
+}
+
+/* takes the next message off the queue
+ * if it can be coalesced, the following messages of the same kind are merged into it,
+ * as long as they fit into one Discord message
+ */
+func (queue *DiscordChannelQueue) next() []*DiscordMessage {
+	queue.Lock()
+	defer queue.Unlock()
+	if len(queue.pending) == 0 {
+		return nil
+	}
+
+	first := queue.pending[0]
+	count := 1
+	if first.Group != "" {
+		length := len(first.Embed.Description)
+		for ; count < len(queue.pending); count++ {
+			message := queue.pending[count]
+			if message.Group != first.Group || length+1+len(message.Embed.Description) > Config().multilineMaxLength() {
+				break
+			}
+			length += len(message.Embed.Description) + 1
+		}
+	} else if first.Coalesce && first.EditID == "" && first.Webhook == nil {
+		length := messageLength(first)
+		for ; count < len(queue.pending); count++ {
+			message := queue.pending[count]
+			if !message.Coalesce || message.EditID != "" || message.Webhook != nil || (message.Embed == nil) != (first.Embed == nil) {
+				break
+			}
+			if first.Embed != nil {
+				if count >= maxEmbedsPerMessage || length+messageLength(message) > maxEmbedLengthPerPost {
+					break
+				}
+			} else if length+1+messageLength(message) > maxMessageLength {
+				break
+			}
+			length += messageLength(message) + 1
+		}
+	}
+
+	batch := queue.pending[:count:count]
+	queue.pending = queue.pending[count:]
+	return batch
+}
+
+func (queue *DiscordChannelQueue) send(batch []*DiscordMessage) {
+	request := batch
+	if batch[0].Group != "" {
+		request = []*DiscordMessage{queue.joinGroup(batch)}
+	}
+
+	sent, err := queue.deliver(request, len(batch))
+	if err != nil && batch[0].Group != "" && request[0].EditID != "" {
+		// the message of the group is gone, i.e. deleted by a moderator, so the lines start a new one
+		subsystemLogger("discordqueue").Warn("Could not extend the grouped message, sending a new one", "channel_id", queue.channelID, "error", err)
+		queue.group = nil
+		request = []*DiscordMessage{queue.joinGroup(batch)}
+		sent, err = queue.deliver(request, len(batch))
+	}
+
+	if err == nil && request[0].EditID == "" {
+		queue.seen(sent.ID)
+	}
+	if batch[0].Group != "" {
+		queue.updateGroup(batch[0].Group, request[0], sent, err)
+	}
+	for _, message := range batch {
+		if message.OnSent != nil {
+			message.OnSent(sent, err)
+		}
+	}
+}
+
+// sends a request, retrying failures that might go away. count is the number of queued messages it contains
+func (queue *DiscordChannelQueue) deliver(request []*DiscordMessage, count int) (*discordgo.Message, error) {
+	for attempt := 1; ; attempt++ {
+		sent, err := deliverDiscordMessages(queue.channelID, request)
+		if err == nil {
+			atomic.AddUint64(&metrics.channel(queue.channelID).MessagesSent, uint64(count))
+			return sent, nil
+		}
+		atomic.AddUint64(&metrics.channel(queue.channelID).DiscordErrors, 1)
+		delay, retry := discordRetryDelay(err, attempt)
+		if !retry || attempt >= maxDiscordSendAttempts {
+			subsystemLogger("discordqueue").Error("Could not send messages", "channel_id", queue.channelID, "count", count, "attempts", attempt, "error", err)
+			return nil, err
+		}
+		subsystemLogger("discordqueue").Warn("Sending failed, retrying", "channel_id", queue.channelID, "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+	}
+}
+
+// turns grouped messages into an edit of the last message of the group, or into the first message of a new group
+func (queue *DiscordChannelQueue) joinGroup(batch []*DiscordMessage) *DiscordMessage {
+	lines := make([]string, len(batch))
+	for i, message := range batch {
+		lines[i] = message.Embed.Description
+	}
+	text := strings.Join(lines, "\n")
+
+	queue.Lock()
+	newestID := queue.newestID
+	queue.Unlock()
+	group := queue.group
+	if group != nil &&
+		group.key == batch[0].Group &&
+		group.message.ID == newestID &&
+		time.Since(group.started) < Config().multilineGroupWindow() &&
+		len(group.embed.Description)+1+len(text) <= Config().multilineMaxLength() {
+		embed := *group.embed
+		embed.Description += "\n" + text
+		return &DiscordMessage{Embed: &embed, EditID: group.message.ID}
+	}
+
+	embed := *batch[0].Embed
+	embed.Description = text
+	return &DiscordMessage{Embed: &embed}
+}
+
+func (queue *DiscordChannelQueue) updateGroup(key string, request *DiscordMessage, sent *discordgo.Message, err error) {
+	switch {
+	case err != nil:
+		queue.group = nil
+	case request.EditID != "":
+		queue.group.embed = request.Embed
+	default:
+		queue.group = &MessageGroup{key: key, message: sent, embed: request.Embed, started: time.Now()}
+	}
+}
+
+func deliverDiscordMessages(channelID string, batch []*DiscordMessage) (*discordgo.Message, error) {
+	first := batch[0]
+	if first.Webhook != nil {
+		sent, err := discord.WebhookExecute(first.Webhook.ID, first.Webhook.Token, true, &discordgo.WebhookParams{
+			Content:   first.Content,
+			Username:  first.Username,
+			AvatarURL: first.AvatarURL,
+			// players must not be able to ping anyone
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if hasDiscordStatus(err, http.StatusNotFound) {
+			// the webhook was deleted, a new one is created for the next message
+			channelWebhooks.forget(channelID)
+		}
+		return sent, err
+	}
+	if first.EditID != "" {
+		edit := discordgo.NewMessageEdit(channelID, first.EditID)
+		if first.Embed != nil {
+			edit.SetEmbed(first.Embed)
+		} else {
+			edit.SetContent(first.Content)
+		}
+		return discord.ChannelMessageEditComplex(edit)
+	}
+
+	data := &discordgo.MessageSend{}
+	contents := make([]string, 0, len(batch))
+	for _, message := range batch {
+		if message.Embed != nil {
+			data.Embeds = append(data.Embeds, message.Embed)
+		}
+		if message.Content != "" {
+			contents = append(contents, message.Content)
+		}
+	}
+	data.Content = strings.Join(contents, "\n")
+	return discord.ChannelMessageSendComplex(channelID, data)
+}
+
+/* decides whether a failed request is retried and how long to wait before
+ * rate limits are waited out, server and network errors are retried with an exponential backoff,
+ * everything else (missing permissions, invalid messages, ...) would fail again
+ */
+func discordRetryDelay(err error, attempt int) (time.Duration, bool) {
+	var rateLimitError *discordgo.RateLimitError
+	if errors.As(err, &rateLimitError) {
+		return rateLimitError.RetryAfter, true
+	}
+
+	var restError *discordgo.RESTError
+	if errors.As(err, &restError) && restError.Response != nil {
+		status := restError.Response.StatusCode
+		if status != http.StatusTooManyRequests && status < 500 {
+			return 0, false
+		}
+	}
+
+	delay := discordRetryBaseDelay << uint(attempt-1)
+	if delay > discordRetryMaxDelay {
+		delay = discordRetryMaxDelay
+	}
+	return delay, true
+}
+
+// checks if a request was answered by Discord with the given http status
+func hasDiscordStatus(err error, status int) bool {
+	var restError *discordgo.RESTError
+	if errors.As(err, &restError) && restError.Response != nil {
+		return restError.Response.StatusCode == status
+	}
+	return false
+}
+
+// the length of a message as Discord counts it for its limits
+func messageLength(message *DiscordMessage) int {
+	if message.Embed == nil {
+		return len(message.Content)
+	}
+	embed := message.Embed
+	length := len(embed.Title) + len(embed.Description)
+	if embed.Author != nil {
+		length += len(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		length += len(embed.Footer.Text)
+	}
+	for _, field := range embed.Fields {
+		length += len(field.Name) + len(field.Value)
+	}
+	return length
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func discordStatusError(status int) error {
+	return &discordgo.RESTError{Response: &http.Response{StatusCode: status}}
+}
+
+func rateLimitError(retryAfter time.Duration) error {
+	return &discordgo.RateLimitError{RateLimit: &discordgo.RateLimit{TooManyRequests: &discordgo.TooManyRequests{RetryAfter: retryAfter}}}
+}
+
+func TestDiscordQueueCoalescesMessages(t *testing.T) {
+	useConfig(t, &Configuration{})
+	status := func(text string) *DiscordMessage {
+		return &DiscordMessage{Embed: &discordgo.MessageEmbed{Description: text}, Coalesce: true}
+	}
+	chat := func(text string) *DiscordMessage {
+		return &DiscordMessage{Embed: &discordgo.MessageEmbed{Description: text}, Group: "chat-Brute"}
+	}
+	tests := []struct {
+		name    string
+		pending []*DiscordMessage
+		batches []int
+	}{
+		{"single message", []*DiscordMessage{status("join")}, []int{1}},
+		{"coalesced embeds", []*DiscordMessage{status("join"), status("leave"), status("join")}, []int{3}},
+		{"texts are not merged with embeds", []*DiscordMessage{status("join"), {Content: "a", Coalesce: true}, {Content: "b", Coalesce: true}}, []int{1, 2}},
+		{"messages that may not be coalesced", []*DiscordMessage{{Content: "a"}, {Content: "b", Coalesce: true}}, []int{1, 1}},
+		{"at most 10 embeds", []*DiscordMessage{
+			status("1"), status("2"), status("3"), status("4"), status("5"), status("6"), status("7"), status("8"), status("9"), status("10"), status("11"),
+		}, []int{10, 1}},
+		{"texts up to the message length", []*DiscordMessage{
+			{Content: strings.Repeat("a", 1500), Coalesce: true},
+			{Content: strings.Repeat("b", 499), Coalesce: true},
+			{Content: "c", Coalesce: true},
+		}, []int{2, 1}},
+		{"edits are sent on their own", []*DiscordMessage{status("join"), {Content: "a", EditID: "5", Coalesce: true}}, []int{1, 1}},
+		{"grouped lines", []*DiscordMessage{chat("hi"), chat("how are you"), status("leave"), chat("bye")}, []int{2, 1, 1}},
+		{"groups of other players", []*DiscordMessage{chat("hi"), {Embed: &discordgo.MessageEmbed{Description: "yo"}, Group: "chat-Wooza"}}, []int{1, 1}},
+	}
+	for _, test := range tests {
+		queue := &DiscordChannelQueue{channelID: "240", pending: test.pending}
+		var batches []int
+		for batch := queue.next(); batch != nil; batch = queue.next() {
+			batches = append(batches, len(batch))
+		}
+		if !reflect.DeepEqual(batches, test.batches) {
+			t.Errorf("%s: expected batches of %v, got %v", test.name, test.batches, batches)
+		}
+	}
+}
+
+func TestDiscordQueueSendsCoalescedMessagesAsOne(t *testing.T) {
+	fake, _ := newTestBridge(t, "241", ServerConfig{})
+	queue := &DiscordChannelQueue{channelID: "241"}
+	queue.send([]*DiscordMessage{{Content: "a", Coalesce: true}, {Content: "b", Coalesce: true}})
+	if message := fake.nextMessage(t); message.Content != "a\nb" {
+		t.Errorf("expected one message with both lines, got %+v", message)
+	}
+}
+
+func TestDiscordQueueRetries(t *testing.T) {
+	fake, _ := newTestBridge(t, "242", ServerConfig{})
+	queue := &DiscordChannelQueue{channelID: "242"}
+
+	// rate limits are waited out
+	fake.failNext(rateLimitError(time.Millisecond), rateLimitError(time.Millisecond))
+	var sentErr error
+	queue.send([]*DiscordMessage{{Content: "hello", OnSent: func(message *discordgo.Message, err error) { sentErr = err }}})
+	if message := fake.nextMessage(t); message.Content != "hello" || sentErr != nil {
+		t.Errorf("expected the message to be sent after the rate limit, got %+v, %v", message, sentErr)
+	}
+
+	// missing permissions would fail again
+	fake.failNext(discordStatusError(http.StatusForbidden))
+	queue.send([]*DiscordMessage{{Content: "forbidden", OnSent: func(message *discordgo.Message, err error) { sentErr = err }}})
+	fake.expectNoMessage(t)
+	if !hasDiscordStatus(sentErr, http.StatusForbidden) {
+		t.Errorf("expected the error to be passed on, got %v", sentErr)
+	}
+}
+
+func TestDiscordRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		delay   time.Duration
+		retry   bool
+	}{
+		{"rate limit", rateLimitError(3 * time.Second), 1, 3 * time.Second, true},
+		{"too many requests", discordStatusError(http.StatusTooManyRequests), 1, discordRetryBaseDelay, true},
+		{"server error", discordStatusError(http.StatusBadGateway), 1, discordRetryBaseDelay, true},
+		{"server error again", discordStatusError(http.StatusBadGateway), 3, 4 * discordRetryBaseDelay, true},
+		{"backoff is capped", discordStatusError(http.StatusInternalServerError), 10, discordRetryMaxDelay, true},
+		{"network error", errors.New("connection reset"), 2, 2 * discordRetryBaseDelay, true},
+		{"forbidden", discordStatusError(http.StatusForbidden), 1, 0, false},
+		{"not found", discordStatusError(http.StatusNotFound), 1, 0, false},
+		{"invalid message", discordStatusError(http.StatusBadRequest), 1, 0, false},
+	}
+	for _, test := range tests {
+		delay, retry := discordRetryDelay(test.err, test.attempt)
+		if delay != test.delay || retry != test.retry {
+			t.Errorf("%s: discordRetryDelay = %v, %v, want %v, %v", test.name, delay, retry, test.delay, test.retry)
+		}
+	}
+}
+
+func TestDiscordQueueStartsNewGroupWhenSomeoneWroteInBetween(t *testing.T) {
+	fake, server := newTestBridge(t, "243", ServerConfig{})
+
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "hello"))
+	first := fake.nextMessage(t)
+	discordQueues.get("243").seen("999999999")
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "again"))
+	if message := fake.nextMessage(t); message.Edit || message.ID == first.ID || message.Embeds[0].Description != "again" {
+		t.Errorf("expected a new message, got %+v", message)
+	}
+}
+
+func TestDiscordQueueStartsNewGroupWhenTheGroupWasDeleted(t *testing.T) {
+	fake, server := newTestBridge(t, "244", ServerConfig{})
+
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "hello"))
+	first := fake.nextMessage(t)
+
+	// a moderator deleted the message, so it can't be edited anymore
+	fake.failNext(discordStatusError(http.StatusNotFound))
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "again"))
+	second := fake.nextMessage(t)
+	if second.Edit || second.ID == first.ID || second.Embeds[0].Description != "again" {
+		t.Fatalf("expected the line in a new message, got %+v", second)
+	}
+
+	// the following lines are grouped with the new message
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "third"))
+	if message := fake.nextMessage(t); !message.Edit || message.ID != second.ID || message.Embeds[0].Description != "again\nthird" {
+		t.Errorf("expected the new message to be edited, got %+v", message)
+	}
+}
@@ -0,0 +1,123 @@
+// This file contains the interface over the Discord operations the bridge uses.
+// The bridge talks to Discord only through it, so the whole path from the game to Discord and back
+// can be tested with an in-memory guild instead of a real bot connection
+
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+type DiscordSession interface {
+	// the user of the bot
+	BotUserID() string
+
+	// what the bot knows about the guilds it is in, from the gateway state
+	Guilds() []*discordgo.Guild
+	Guild(guildID string) (*discordgo.Guild, error)
+	GuildChannels(guildID string) ([]*discordgo.Channel, error)
+	Channel(channelID string) (*discordgo.Channel, error)
+	Member(guildID string, userID string) (*discordgo.Member, error)
+	Role(guildID string, roleID string) (*discordgo.Role, error)
+	User(userID string) (*discordgo.User, error)
+
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	ChannelMessageEditComplex(edit *discordgo.MessageEdit) (*discordgo.Message, error)
+	UserChannelCreate(userID string) (*discordgo.Channel, error)
+	ChannelWebhooks(channelID string) ([]*discordgo.Webhook, error)
+	WebhookCreate(channelID string, name string, avatar string) (*discordgo.Webhook, error)
+	WebhookExecute(webhookID string, token string, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error)
+	UpdateGameStatus(idle int, name string) error
+
+	// slash commands and the answers to their interactions
+	ApplicationCommandBulkOverwrite(guildID string, commands []*discordgo.ApplicationCommand) ([]*discordgo.ApplicationCommand, error)
+	InteractionRespond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error)
+}
+
+// the session of the running bot, backed by the gateway connection
+type gatewaySession struct {
+	session *discordgo.Session
+}
+
+func (s gatewaySession) BotUserID() string {
+	if s.session.State.User == nil {
+		return ""
+	}
+	return s.session.State.User.ID
+}
+
+func (s gatewaySession) Guilds() []*discordgo.Guild {
+	s.session.State.RLock()
+	defer s.session.State.RUnlock()
+	return append([]*discordgo.Guild(nil), s.session.State.Guilds...)
+}
+
+func (s gatewaySession) Guild(guildID string) (*discordgo.Guild, error) {
+	return s.session.State.Guild(guildID)
+}
+
+func (s gatewaySession) GuildChannels(guildID string) ([]*discordgo.Channel, error) {
+	return s.session.GuildChannels(guildID)
+}
+
+func (s gatewaySession) Channel(channelID string) (*discordgo.Channel, error) {
+	return s.session.State.Channel(channelID)
+}
+
+func (s gatewaySession) Member(guildID string, userID string) (*discordgo.Member, error) {
+	return s.session.State.Member(guildID, userID)
+}
+
+func (s gatewaySession) Role(guildID string, roleID string) (*discordgo.Role, error) {
+	return s.session.State.Role(guildID, roleID)
+}
+
+func (s gatewaySession) User(userID string) (*discordgo.User, error) {
+	return s.session.User(userID)
+}
+
+func (s gatewaySession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	return s.session.ChannelMessageSendComplex(channelID, data)
+}
+
+func (s gatewaySession) ChannelMessageEditComplex(edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	return s.session.ChannelMessageEditComplex(edit)
+}
+
+func (s gatewaySession) UserChannelCreate(userID string) (*discordgo.Channel, error) {
+	return s.session.UserChannelCreate(userID)
+}
+
+func (s gatewaySession) ChannelWebhooks(channelID string) ([]*discordgo.Webhook, error) {
+	return s.session.ChannelWebhooks(channelID)
+}
+
+func (s gatewaySession) WebhookCreate(channelID string, name string, avatar string) (*discordgo.Webhook, error) {
+	return s.session.WebhookCreate(channelID, name, avatar)
+}
+
+func (s gatewaySession) WebhookExecute(webhookID string, token string, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error) {
+	return s.session.WebhookExecute(webhookID, token, wait, data)
+}
+
+func (s gatewaySession) UpdateGameStatus(idle int, name string) error {
+	return s.session.UpdateGameStatus(idle, name)
+}
+
+func (s gatewaySession) ApplicationCommandBulkOverwrite(guildID string, commands []*discordgo.ApplicationCommand) ([]*discordgo.ApplicationCommand, error) {
+	return s.session.ApplicationCommandBulkOverwrite(s.BotUserID(), guildID, commands)
+}
+
+func (s gatewaySession) InteractionRespond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	return s.session.InteractionRespond(interaction, response)
+}
+
+func (s gatewaySession) InteractionResponseEdit(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	return s.session.InteractionResponseEdit(interaction, edit)
+}
+
+func (s gatewaySession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error) {
+	return s.session.FollowupMessageCreate(interaction, wait, data)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// a message the bridge sent, edited or posted through a webhook
+type FakeMessage struct {
+	ChannelID string
+	ID        string
+	Content   string
+	Embeds    []*discordgo.MessageEmbed
+	// set if the message was an edit of an existing one
+	Edit bool
+	// set if the message was posted through a webhook
+	WebhookUsername string
+	// set if the message answers an interaction, and whether only the user who invoked it can see it
+	InteractionID string
+	Ephemeral     bool
+}
+
+// an in-memory guild for the tests, it records everything the bridge sends to Discord
+type FakeDiscordSession struct {
+	sync.Mutex
+	botID    string
+	guilds   []*discordgo.Guild
+	webhooks map[string][]*discordgo.Webhook
+	commands map[string][]*discordgo.ApplicationCommand
+	// the flags of the interactions that were acknowledged
+	interactions map[string]discordgo.MessageFlags
+	// the errors the next requests that post messages or manage webhooks fail with
+	failures []error
+	// how often the webhooks of a channel were requested
+	webhookLookups int
+	// if set, looking up the webhooks of a channel waits until it is closed
+	webhookGate chan struct{}
+	status      string
+	nextID      int
+	sent        chan FakeMessage
+}
+
+var errFakeNotFound = errors.New("not found")
+
+func newFakeDiscordSession() *FakeDiscordSession {
+	return &FakeDiscordSession{
+		botID:        "1000",
+		webhooks:     make(map[string][]*discordgo.Webhook),
+		commands:     make(map[string][]*discordgo.ApplicationCommand),
+		interactions: make(map[string]discordgo.MessageFlags),
+		nextID:       100000,
+		sent:         make(chan FakeMessage, 100),
+	}
+}
+
+// replaces the Discord session of the bridge with a fake until the test ends
+func useFakeDiscordSession(t *testing.T) *FakeDiscordSession {
+	fake := newFakeDiscordSession()
+	previousSession, previousBotID := discord, botID
+	discord, botID = fake, fake.botID
+	t.Cleanup(func() { discord, botID = previousSession, previousBotID })
+	return fake
+}
+
+func (fake *FakeDiscordSession) addGuild(guildID string, name string) *discordgo.Guild {
+	fake.Lock()
+	defer fake.Unlock()
+	guild := &discordgo.Guild{ID: guildID, Name: name}
+	fake.guilds = append(fake.guilds, guild)
+	return guild
+}
+
+func (fake *FakeDiscordSession) addChannel(guild *discordgo.Guild, channelID string, name string) {
+	fake.Lock()
+	defer fake.Unlock()
+	guild.Channels = append(guild.Channels, &discordgo.Channel{ID: channelID, GuildID: guild.ID, Name: name})
+}
+
+func (fake *FakeDiscordSession) addRole(guild *discordgo.Guild, roleID string, name string) {
+	fake.Lock()
+	defer fake.Unlock()
+	guild.Roles = append(guild.Roles, &discordgo.Role{ID: roleID, Name: name})
+}
+
+func (fake *FakeDiscordSession) addMember(guild *discordgo.Guild, userID string, username string, roles ...string) *discordgo.Member {
+	fake.Lock()
+	defer fake.Unlock()
+	member := &discordgo.Member{
+		GuildID: guild.ID,
+		User:    &discordgo.User{ID: userID, Username: username, Discriminator: "0001"},
+		Roles:   roles,
+	}
+	guild.Members = append(guild.Members, member)
+	return member
+}
+
+// waits for the next message the bridge sends
+func (fake *FakeDiscordSession) nextMessage(t *testing.T) FakeMessage {
+	t.Helper()
+	select {
+	case message := <-fake.sent:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a Discord message")
+		return FakeMessage{}
+	}
+}
+
+// checks that the bridge did not send anything within a short while
+func (fake *FakeDiscordSession) expectNoMessage(t *testing.T) {
+	t.Helper()
+	select {
+	case message := <-fake.sent:
+		t.Fatalf("unexpected Discord message %+v", message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// lets the next requests that post messages or manage webhooks fail with the given errors
+func (fake *FakeDiscordSession) failNext(errs ...error) {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.failures = append(fake.failures, errs...)
+}
+
+// has to be called with the lock held
+func (fake *FakeDiscordSession) nextFailure() error {
+	if len(fake.failures) == 0 {
+		return nil
+	}
+	err := fake.failures[0]
+	fake.failures = fake.failures[1:]
+	return err
+}
+
+// has to be called with the lock held
+func (fake *FakeDiscordSession) record(message FakeMessage) *discordgo.Message {
+	if message.ID == "" {
+		fake.nextID++
+		message.ID = strconv.Itoa(fake.nextID)
+	}
+	fake.sent <- message
+	return &discordgo.Message{ID: message.ID, ChannelID: message.ChannelID, Content: message.Content, Embeds: message.Embeds}
+}
+
+func (fake *FakeDiscordSession) BotUserID() string {
+	return fake.botID
+}
+
+func (fake *FakeDiscordSession) Guilds() []*discordgo.Guild {
+	fake.Lock()
+	defer fake.Unlock()
+	return append([]*discordgo.Guild(nil), fake.guilds...)
+}
+
+func (fake *FakeDiscordSession) Guild(guildID string) (*discordgo.Guild, error) {
+	for _, guild := range fake.Guilds() {
+		if guild.ID == guildID {
+			return guild, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) GuildChannels(guildID string) ([]*discordgo.Channel, error) {
+	guild, err := fake.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return guild.Channels, nil
+}
+
+func (fake *FakeDiscordSession) Channel(channelID string) (*discordgo.Channel, error) {
+	for _, guild := range fake.Guilds() {
+		for _, channel := range guild.Channels {
+			if channel.ID == channelID {
+				return channel, nil
+			}
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) Member(guildID string, userID string) (*discordgo.Member, error) {
+	guild, err := fake.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range guild.Members {
+		if member.User.ID == userID {
+			return member, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) Role(guildID string, roleID string) (*discordgo.Role, error) {
+	guild, err := fake.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range guild.Roles {
+		if role.ID == roleID {
+			return role, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) User(userID string) (*discordgo.User, error) {
+	for _, guild := range fake.Guilds() {
+		for _, member := range guild.Members {
+			if member.User.ID == userID {
+				return member.User, nil
+			}
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	if err := fake.nextFailure(); err != nil {
+		return nil, err
+	}
+	return fake.record(FakeMessage{ChannelID: channelID, Content: data.Content, Embeds: data.Embeds}), nil
+}
+
+func (fake *FakeDiscordSession) ChannelMessageEditComplex(edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	if err := fake.nextFailure(); err != nil {
+		return nil, err
+	}
+	message := FakeMessage{ChannelID: edit.Channel, ID: edit.ID, Edit: true}
+	if edit.Content != nil {
+		message.Content = *edit.Content
+	}
+	message.Embeds = edit.Embeds
+	return fake.record(message), nil
+}
+
+func (fake *FakeDiscordSession) UserChannelCreate(userID string) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + userID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (fake *FakeDiscordSession) ChannelWebhooks(channelID string) ([]*discordgo.Webhook, error) {
+	fake.Lock()
+	gate := fake.webhookGate
+	fake.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	fake.Lock()
+	defer fake.Unlock()
+	fake.webhookLookups++
+	if err := fake.nextFailure(); err != nil {
+		return nil, err
+	}
+	return fake.webhooks[channelID], nil
+}
+
+func (fake *FakeDiscordSession) WebhookCreate(channelID string, name string, avatar string) (*discordgo.Webhook, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	if err := fake.nextFailure(); err != nil {
+		return nil, err
+	}
+	fake.nextID++
+	webhook := &discordgo.Webhook{
+		ID:        strconv.Itoa(fake.nextID),
+		ChannelID: channelID,
+		Name:      name,
+		Token:     "token",
+		User:      &discordgo.User{ID: fake.botID},
+	}
+	fake.webhooks[channelID] = append(fake.webhooks[channelID], webhook)
+	return webhook, nil
+}
+
+func (fake *FakeDiscordSession) WebhookExecute(webhookID string, token string, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	if err := fake.nextFailure(); err != nil {
+		return nil, err
+	}
+	for channelID, webhooks := range fake.webhooks {
+		for _, webhook := range webhooks {
+			if webhook.ID == webhookID && webhook.Token == token {
+				return fake.record(FakeMessage{ChannelID: channelID, Content: data.Content, Embeds: data.Embeds, WebhookUsername: data.Username}), nil
+			}
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (fake *FakeDiscordSession) UpdateGameStatus(idle int, name string) error {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.status = name
+	return nil
+}
+
+func (fake *FakeDiscordSession) ApplicationCommandBulkOverwrite(guildID string, commands []*discordgo.ApplicationCommand) ([]*discordgo.ApplicationCommand, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	fake.commands[guildID] = commands
+	return commands, nil
+}
+
+func (fake *FakeDiscordSession) InteractionRespond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	fake.Lock()
+	defer fake.Unlock()
+	if _, ok := fake.interactions[interaction.ID]; ok {
+		return errors.New("interaction has already been acknowledged")
+	}
+	var flags discordgo.MessageFlags
+	if response.Data != nil {
+		flags = response.Data.Flags
+	}
+	fake.interactions[interaction.ID] = flags
+	return nil
+}
+
+func (fake *FakeDiscordSession) InteractionResponseEdit(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	flags, ok := fake.interactions[interaction.ID]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	message := FakeMessage{ChannelID: interaction.ChannelID, InteractionID: interaction.ID, Ephemeral: flags&discordgo.MessageFlagsEphemeral != 0}
+	if edit.Content != nil {
+		message.Content = *edit.Content
+	}
+	if edit.Embeds != nil {
+		message.Embeds = *edit.Embeds
+	}
+	return fake.record(message), nil
+}
+
+func (fake *FakeDiscordSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error) {
+	fake.Lock()
+	defer fake.Unlock()
+	if _, ok := fake.interactions[interaction.ID]; !ok {
+		return nil, errFakeNotFound
+	}
+	return fake.record(FakeMessage{
+		ChannelID:     interaction.ChannelID,
+		Content:       data.Content,
+		Embeds:        data.Embeds,
+		InteractionID: interaction.ID,
+		Ephemeral:     data.Flags&discordgo.MessageFlagsEphemeral != 0,
+	}), nil
+}
@@ -31,6 +31,10 @@ func mentionTranslator(mentions []*discordgo.User, guild *discordgo.Guild) func(
 		id := strings.Trim(match, "\\<@!>")
 		for _, mention := range mentions {
 			if mention.ID == id {
+				// linked users are shown with the name the players know them by
+				if playerName, ok := accountLinks.playerName(id); ok {
+					return "@" + playerName
+				}
 				return "@" + getUserNickname(mention, guild)
 			}
 		}
@@ -41,7 +45,7 @@ func mentionTranslator(mentions []*discordgo.User, guild *discordgo.Guild) func(
 func roleTranslator(guild *discordgo.Guild) func(string) string {
 	return func(match string) string {
 		roleID := strings.Trim(match, "\\<@&>")
-		role, err := session.State.Role(guild.ID, roleID)
+		role, err := discord.Role(guild.ID, roleID)
 		if err == nil {
 			return "@" + role.Name
 		}
@@ -52,7 +56,7 @@ func roleTranslator(guild *discordgo.Guild) func(string) string {
 func channelTranslator() func(string) string {
 	return func(match string) string {
 		id := strings.Trim(match, "\\<#>")
-		if channel, err := session.State.Channel(id); err == nil {
+		if channel, err := discord.Channel(id); err == nil {
 			return "#" + channel.Name
 		} else {
 			return "#deleted-channel"
@@ -91,11 +95,7 @@ func sanitizeForGame(text string) string {
 }
 
 // formats a discord message so it looks good in-game
-func formatDiscordMessage(m *discordgo.MessageCreate) string {
-	guild, err := getGuildForChannel(session, m.ChannelID)
-	if err != nil {
-		panic(err.Error())
-	}
+func formatDiscordMessage(m *discordgo.MessageCreate, guild *discordgo.Guild) string {
 	message := mentionPattern.ReplaceAllStringFunc(m.Content, mentionTranslator(m.Mentions, guild))
 	message = rolePattern.ReplaceAllStringFunc(message, roleTranslator(guild))
 	message = channelPattern.ReplaceAllStringFunc(message, channelTranslator())
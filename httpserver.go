@@ -0,0 +1,149 @@
+// This file contains the HTTP server that the Shine DiscordBridge mod talks to.
+// The mod posts its events to /discordbridge, tagged with the server identifier from its config.
+// This allows the bridge to run on a different machine than the game server. If a secret is configured, every request
+// has to carry it, as the polls hand out the queued rcon commands
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	httpReadTimeout = 10 * time.Second
+	// a poll is answered after outboundPollTimeout at the latest
+	httpWriteTimeout = outboundPollTimeout + 10*time.Second
+	httpIdleTimeout  = 2 * time.Minute
+)
+
+func startHTTPServer() {
+	address := Config().HttpServer.Address
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/discordbridge", httpHandler)
+
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	go func() {
+		subsystemLogger("httpserver").Info("Listening", "address", address)
+		if err := httpServer.ListenAndServe(); err != nil {
+			subsystemLogger("httpserver").Error("HTTP server stopped", "error", err)
+		}
+	}()
+}
+
+func httpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	serverName := r.PostFormValue("id")
+	if !hasHTTPSecret(r) {
+		serverLogger("httpserver", serverName).Warn("Received request without the secret", "remote_addr", r.RemoteAddr)
+		http.Error(w, "wrong secret", http.StatusUnauthorized)
+		return
+	}
+	server, ok := serverList.get(serverName)
+	if !ok {
+		serverLogger("httpserver", serverName).Warn("Received message for unknown server", "remote_addr", r.RemoteAddr)
+		http.Error(w, "unknown server identifier", http.StatusNotFound)
+		return
+	}
+
+	if strings.ToLower(r.PostFormValue("type")) == "poll" {
+		handlePoll(w, r, server)
+		return
+	}
+
+	event, ok := parseHTTPEvent(r)
+	if !ok {
+		serverLogger("httpserver", serverName).Warn("Received unknown message type", "type", r.PostFormValue("type"))
+		http.Error(w, "unknown message type", http.StatusBadRequest)
+		return
+	}
+	serverLogger("httpserver", serverName).Debug("Received event", "kind", event.Kind(), "event", fmt.Sprintf("%+v", event))
+	eventBus.publish(server, event)
+	w.WriteHeader(http.StatusOK)
+}
+
+// checks the secret of a request, requests don't need one if none is configured
+func hasHTTPSecret(r *http.Request) bool {
+	secret := Config().HttpServer.Secret
+	if secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.PostFormValue("secret")), []byte(secret)) == 1
+}
+
+/* long polling for messages from Discord
+ * the mod acknowledges the id of the last message it received with the next poll, until then messages are resent
+ */
+func handlePoll(w http.ResponseWriter, r *http.Request, server *Server) {
+	ack, _ := strconv.ParseUint(r.PostFormValue("ack"), 10, 64)
+	messages := server.Outbound.poll(r.Context(), ack)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Messages []OutboundMessage `json:"messages"`
+	}{messages})
+}
+
+// turns the form values posted by the mod into the same events the log parser produces
+func parseHTTPEvent(r *http.Request) (LogEvent, bool) {
+	switch strings.ToLower(r.PostFormValue("type")) {
+	case "chat":
+		return ChatEvent{
+			Name:     r.PostFormValue("plyr"),
+			SteamID:  parseSteamID3(r.PostFormValue("sid")),
+			Team:     parseTeamNumber(r.PostFormValue("team")),
+			Message:  r.PostFormValue("msg"),
+			FromHTTP: true,
+		}, true
+	case "player":
+		return PlayerEvent{
+			Action:      r.PostFormValue("act"),
+			Name:        r.PostFormValue("plyr"),
+			SteamID:     parseSteamID3(r.PostFormValue("sid")),
+			PlayerCount: r.PostFormValue("pc"),
+		}, true
+	case "status":
+		return StatusEvent{
+			State:       r.PostFormValue("sv_status"),
+			Map:         r.PostFormValue("map"),
+			PlayerCount: r.PostFormValue("pc"),
+		}, true
+	case "changemap":
+		return ChangeMapEvent{
+			Map:         r.PostFormValue("map"),
+			PlayerCount: r.PostFormValue("pc"),
+		}, true
+	case "init":
+		return InitEvent{
+			Map: r.PostFormValue("map"),
+		}, true
+	case "adminprint":
+		return AdminPrintEvent{
+			Message: r.PostFormValue("msg"),
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func postToBridge(values url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/discordbridge", strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	httpHandler(response, request)
+	return response
+}
+
+func TestHTTPEventsArePublished(t *testing.T) {
+	fake, server := newTestBridge(t, "280", ServerConfig{})
+	events := collectEvents(server)
+
+	response := postToBridge(url.Values{"id": {server.Name}, "type": {"chat"}, "plyr": {"Brute"}, "sid": {"11345"}, "team": {"2"}, "msg": {"hello"}})
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", response.Code)
+	}
+	expected := ChatEvent{Name: "Brute", SteamID: 11345, Team: 2, Message: "hello", FromHTTP: true}
+	if event := nextEvent(t, events); event != expected {
+		t.Errorf("expected %+v, got %+v", expected, event)
+	}
+	if message := fake.nextMessage(t); message.ChannelID != "280" {
+		t.Errorf("expected the chat message in the linked channel, got %+v", message)
+	}
+
+	postToBridge(url.Values{"id": {server.Name}, "type": {"status"}, "sv_status": {"Started"}, "map": {"ns2_veil"}, "pc": {"12/24"}})
+	if event := nextEvent(t, events); event != (StatusEvent{State: "Started", Map: "ns2_veil", PlayerCount: "12/24"}) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	fake.nextMessage(t)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	_, server := newTestBridge(t, "281", ServerConfig{})
+
+	tests := []struct {
+		name   string
+		values url.Values
+		status int
+	}{
+		{"unknown server", url.Values{"id": {"unknown"}, "type": {"chat"}}, http.StatusNotFound},
+		{"unknown type", url.Values{"id": {server.Name}, "type": {"vote"}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if response := postToBridge(test.values); response.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, response.Code, test.status)
+		}
+	}
+
+	response := httptest.NewRecorder()
+	httpHandler(response, httptest.NewRequest(http.MethodGet, "/discordbridge?id="+server.Name+"&type=poll", nil))
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got status %d", response.Code)
+	}
+}
+
+func pollBridge(t *testing.T, server *Server, ack uint64) []OutboundMessage {
+	t.Helper()
+	response := postToBridge(url.Values{"id": {server.Name}, "type": {"poll"}, "ack": {strconv.FormatUint(ack, 10)}})
+	var body struct {
+		Messages []OutboundMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %q", response.Code, response.Body.String())
+	}
+	return body.Messages
+}
+
+func TestHTTPPollAcknowledgesMessages(t *testing.T) {
+	_, server := newTestBridge(t, "282", ServerConfig{})
+	server.Outbound.push("chat", "Brute", "hello")
+	server.Outbound.push("rcon", "", "sv_say hi")
+
+	messages := pollBridge(t, server, 0)
+	if len(messages) != 2 || messages[0].User != "Brute" || messages[0].Message != "hello" || messages[1].Type != "rcon" {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+	server.Outbound.push("chat", "Brute", "again")
+	if rest := pollBridge(t, server, messages[1].ID); len(rest) != 1 || rest[0].Message != "again" {
+		t.Errorf("expected only the message that was not acknowledged, got %+v", rest)
+	}
+}
+
+func TestHTTPSecret(t *testing.T) {
+	_, server := newTestBridge(t, "283", ServerConfig{})
+	config := *Config()
+	config.HttpServer.Secret = "hunter2"
+	useConfig(t, &config)
+	server.Outbound.push("rcon", "", "sv_ban 11345")
+
+	tests := []struct {
+		name   string
+		secret []string
+		status int
+	}{
+		{"no secret", nil, http.StatusUnauthorized},
+		{"wrong secret", []string{"hunter3"}, http.StatusUnauthorized},
+		{"right secret", []string{"hunter2"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		response := postToBridge(url.Values{"id": {server.Name}, "type": {"poll"}, "secret": test.secret})
+		if response.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, response.Code, test.status)
+		}
+		if test.status != http.StatusOK && strings.Contains(response.Body.String(), "sv_ban") {
+			t.Errorf("%s: the queued command was handed out", test.name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// This file contains the events that the game server reports through its log file.
+// Every --DISCORD-- line is parsed into a typed event, which is then published to everyone who subscribed to the event bus.
+// New kinds of events only need a registered parser, the log parser itself doesn't need to know about them
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const logEventMarker = "--DISCORD--"
+
+type LogEvent interface {
+	Kind() string
+}
+
+type ChatEvent struct {
+	Name    string
+	SteamID SteamID3
+	Team    TeamNumber
+	Message string
+	// set for events that were posted to the HTTP server, whose sender is only known if a secret is configured
+	FromHTTP bool
+}
+
+type PlayerEvent struct {
+	Action      string
+	Name        string
+	SteamID     SteamID3
+	PlayerCount string
+}
+
+type StatusEvent struct {
+	State       string
+	Map         string
+	PlayerCount string
+}
+
+type ChangeMapEvent struct {
+	Map         string
+	PlayerCount string
+}
+
+type InitEvent struct {
+	Map string
+}
+
+type AdminPrintEvent struct {
+	Message string
+}
+
+func (ChatEvent) Kind() string       { return "chat" }
+func (PlayerEvent) Kind() string     { return "player" }
+func (StatusEvent) Kind() string     { return "status" }
+func (ChangeMapEvent) Kind() string  { return "changemap" }
+func (InitEvent) Kind() string       { return "init" }
+func (AdminPrintEvent) Kind() string { return "adminprint" }
+
+type LogEventParser struct {
+	Kind    string
+	Pattern *regexp.Regexp
+	Parse   func(fields []string) LogEvent
+}
+
+type LogEventHandler func(server *Server, event LogEvent)
+
+type EventBus struct {
+	sync.RWMutex
+	handlers []LogEventHandler
+}
+
+var (
+	logEventParsers []LogEventParser
+	eventBus        = &EventBus{}
+)
+
+func init() {
+	registerLogEventParser("chat", 4, func(fields []string) LogEvent {
+		return ChatEvent{
+			Name:    fields[0],
+			SteamID: parseSteamID3(fields[1]),
+			Team:    parseTeamNumber(fields[2]),
+			Message: fields[3],
+		}
+	})
+	registerLogEventParser("status", 3, func(fields []string) LogEvent {
+		return StatusEvent{
+			State:       fields[0],
+			Map:         fields[1],
+			PlayerCount: fields[2],
+		}
+	})
+	registerLogEventParser("changemap", 2, func(fields []string) LogEvent {
+		return ChangeMapEvent{
+			Map:         fields[0],
+			PlayerCount: fields[1],
+		}
+	})
+	registerLogEventParser("init", 1, func(fields []string) LogEvent {
+		return InitEvent{
+			Map: fields[0],
+		}
+	})
+	registerLogEventParser("player", 4, func(fields []string) LogEvent {
+		return PlayerEvent{
+			Action:      fields[0],
+			Name:        fields[1],
+			SteamID:     parseSteamID3(fields[2]),
+			PlayerCount: fields[3],
+		}
+	})
+	registerLogEventParser("adminprint", 1, func(fields []string) LogEvent {
+		return AdminPrintEvent{
+			Message: fields[0],
+		}
+	})
+}
+
+/* registers a parser for a kind of log line
+ * the line has the form [hh:mm:ss]--DISCORD--|kind<sep>field1<sep>...<sep>fieldN, where the last field may contain the separator
+ */
+func registerLogEventParser(kind string, fieldCount int, parse func(fields []string) LogEvent) {
+	pattern := regexPrefix + regexp.QuoteMeta(kind)
+	for i := 1; i < fieldCount; i++ {
+		pattern += fieldSep + "(.*?)"
+	}
+	pattern += fieldSep + "(.*)\n"
+	logEventParsers = append(logEventParsers, LogEventParser{
+		Kind:    kind,
+		Pattern: regexp.MustCompile(pattern),
+		Parse:   parse,
+	})
+}
+
+// parses a log line into an event, returns false if the line is no (known) event
+func parseLogLine(line string) (LogEvent, bool) {
+	if !strings.Contains(line, logEventMarker) {
+		return nil, false
+	}
+	for _, parser := range logEventParsers {
+		if matches := parser.Pattern.FindStringSubmatch(line); matches != nil {
+			return parser.Parse(matches[1:]), true
+		}
+	}
+	return nil, false
+}
+
+func parseSteamID3(field string) SteamID3 {
+	steamid, _ := strconv.ParseUint(field, 10, 32)
+	return SteamID3(steamid)
+}
+
+func parseTeamNumber(field string) TeamNumber {
+	teamNumber, _ := strconv.Atoi(field)
+	return TeamNumber(teamNumber)
+}
+
+func (bus *EventBus) subscribe(handler LogEventHandler) {
+	bus.Lock()
+	defer bus.Unlock()
+	bus.handlers = append(bus.handlers, handler)
+}
+
+// hands the event to all subscribers, in the order they subscribed
+func (bus *EventBus) publish(server *Server, event LogEvent) {
+	bus.RLock()
+	handlers := bus.handlers
+	bus.RUnlock()
+	for _, handler := range handlers {
+		handler(server, event)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// builds a log line the way the game server writes it
+func discordLogLine(kind string, fields ...string) string {
+	return "[12:34:56]--DISCORD--|" + kind + fieldSep + strings.Join(fields, fieldSep) + "\n"
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		event LogEvent
+	}{
+		{
+			name:  "chat",
+			line:  discordLogLine("chat", "Brute", "12345", "1", "hello world"),
+			event: ChatEvent{Name: "Brute", SteamID: 12345, Team: 1, Message: "hello world"},
+		},
+		{
+			name:  "chat with separator in message",
+			line:  discordLogLine("chat", "Brute", "12345", "2", "a"+fieldSep+"b"),
+			event: ChatEvent{Name: "Brute", SteamID: 12345, Team: 2, Message: "a" + fieldSep + "b"},
+		},
+		{
+			name:  "chat from bot without steam id",
+			line:  discordLogLine("chat", "Bot", "0", "0", ""),
+			event: ChatEvent{Name: "Bot", SteamID: 0, Team: 0, Message: ""},
+		},
+		{
+			name:  "player join",
+			line:  discordLogLine("player", "join", "Brute", "12345", "1/24"),
+			event: PlayerEvent{Action: "join", Name: "Brute", SteamID: 12345, PlayerCount: "1/24"},
+		},
+		{
+			name:  "player leave",
+			line:  discordLogLine("player", "leave", "Brute", "12345", "0/24"),
+			event: PlayerEvent{Action: "leave", Name: "Brute", SteamID: 12345, PlayerCount: "0/24"},
+		},
+		{
+			name:  "status",
+			line:  discordLogLine("status", "Team1Won", "ns2_summit", "12/24"),
+			event: StatusEvent{State: "Team1Won", Map: "ns2_summit", PlayerCount: "12/24"},
+		},
+		{
+			name:  "changemap",
+			line:  discordLogLine("changemap", "ns2_veil", "12/24"),
+			event: ChangeMapEvent{Map: "ns2_veil", PlayerCount: "12/24"},
+		},
+		{
+			name:  "init",
+			line:  discordLogLine("init", "ns2_veil"),
+			event: InitEvent{Map: "ns2_veil"},
+		},
+		{
+			name:  "adminprint",
+			line:  discordLogLine("adminprint", "Brute kicked a player"),
+			event: AdminPrintEvent{Message: "Brute kicked a player"},
+		},
+		{
+			name: "unknown kind",
+			line: discordLogLine("unknown", "foo"),
+		},
+		{
+			name: "missing fields",
+			line: discordLogLine("chat", "Brute", "12345"),
+		},
+		{
+			name: "incomplete line",
+			line: strings.TrimSuffix(discordLogLine("init", "ns2_veil"), "\n"),
+		},
+		{
+			name: "no discord line",
+			line: "[12:34:56]Client connected\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := parseLogLine(test.line)
+			if ok != (test.event != nil) {
+				t.Fatalf("parseLogLine(%q) matched = %v, want %v", test.line, ok, test.event != nil)
+			}
+			if !reflect.DeepEqual(event, test.event) {
+				t.Errorf("parseLogLine(%q) = %#v, want %#v", test.line, event, test.event)
+			}
+		})
+	}
+}
+
+type testEvent struct {
+	Value string
+}
+
+func (testEvent) Kind() string { return "test" }
+
+func TestRegisterLogEventParser(t *testing.T) {
+	parsers := logEventParsers
+	defer func() { logEventParsers = parsers }()
+
+	registerLogEventParser("test", 1, func(fields []string) LogEvent {
+		return testEvent{Value: fields[0]}
+	})
+	event, ok := parseLogLine(discordLogLine("test", "value"))
+	if !ok || event != (testEvent{Value: "value"}) {
+		t.Errorf("parseLogLine() = %#v, %v, want registered test event", event, ok)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := &EventBus{}
+	server := &Server{Name: "test"}
+	var received []string
+	bus.subscribe(func(s *Server, event LogEvent) {
+		received = append(received, "first "+s.Name+" "+event.Kind())
+	})
+	bus.subscribe(func(s *Server, event LogEvent) {
+		received = append(received, "second "+s.Name+" "+event.Kind())
+	})
+	bus.publish(server, InitEvent{Map: "ns2_veil"})
+
+	expected := []string{"first test init", "second test init"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("received %v, want %v", received, expected)
+	}
+}
@@ -0,0 +1,53 @@
+// This file sets up the leveled, structured logger of the bridge.
+// Every message is tagged with the subsystem that logs it and, where it is about a game server, the name of the server.
+// Level and format are configured in the [logging] section, detailed diagnostics are only shown at the debug level
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	setupLogging(Config())
+}
+
+// replaces the default logger according to the config, the standard log package writes through it as well
+func setupLogging(config *Configuration) {
+	logLevel.Set(parseLogLevel(config.Logging.Level))
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if strings.ToLower(config.Logging.Format) == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// the logger of a part of the bridge, i.e. "discord" or "logparser"
+func subsystemLogger(subsystem string) *slog.Logger {
+	return slog.Default().With("subsystem", subsystem)
+}
+
+// the logger of a part of the bridge that works for one game server
+func serverLogger(subsystem string, serverName string) *slog.Logger {
+	return subsystemLogger(subsystem).With("server", serverName)
+}
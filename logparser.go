@@ -5,124 +5,72 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"regexp"
-	"strconv"
 	"time"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"io"
+	"io/ioutil"
 )
 
 const fieldSep = ""
 const regexPrefix = "^\\[[0-9][0-9]:[0-9][0-9]:[0-9][0-9]\\]--DISCORD--\\|"
 
-var (
-	chatRegexp = regexp.MustCompile(regexPrefix + "chat" +
-		fieldSep + "(.*?)" + // name
-		fieldSep + "(.*?)" + // steam id
-		fieldSep + "(.*?)" + // team number
-		fieldSep + "(.*)\n") // message
-
-	statusRegexp = regexp.MustCompile(regexPrefix + "status" +
-		fieldSep + "(.*?)" + // status
-		fieldSep + "(.*?)" + // map
-		fieldSep + "(.*)\n") // player count
-
-	changemapRegexp = regexp.MustCompile(regexPrefix + "changemap" +
-		fieldSep + "(.*?)" + // map
-		fieldSep + "(.*)\n") // player count
-
-	initRegexp = regexp.MustCompile(regexPrefix + "init" +
-		fieldSep + "(.*)\n") // map
-
-	playerRegexp = regexp.MustCompile(regexPrefix + "player" +
-		fieldSep + "(.*?)" + // action
-		fieldSep + "(.*?)" + // name
-		fieldSep + "(.*?)" + // steam id
-		fieldSep + "(.*)\n") // player count
-
-	adminprintRegexp = regexp.MustCompile(regexPrefix + "adminprint" +
-		fieldSep + "(.*)\n") // message
-)
-
-func init() {
-	// Log the regex patterns at startup for debugging
-	log.Println("[LogParser] Field separator (hex):", strings.ToUpper(fmt.Sprintf("%x", fieldSep)))
-	log.Println("[LogParser] Chat regex pattern:", chatRegexp.String())
-}
-
-func findLogFile(logpath string) string {
-	if logpath == "" {
-		log.Println("[LogParser] WARNING: log_file_path is empty in config!")
-		return ""
-	}
-	
-	log.Printf("[LogParser] Searching for log file based on path: %q", logpath)
+func findLogFile(logger *slog.Logger, logpath string) string {
 	dir := filepath.Dir(logpath)
-	log.Printf("[LogParser] Directory to search: %q", dir)
-	
+	logger.Debug("Searching for log file", "path", logpath, "dir", dir)
+
 	// First, try to check if the exact configured file exists
 	if _, err := os.Stat(logpath); err == nil {
-		log.Printf("[LogParser] NOTE: Configured file exists directly: %q", logpath)
-		log.Printf("[LogParser] Using configured file directly instead of searching")
+		logger.Debug("Configured log file exists, using it directly", "path", logpath)
 		return logpath
 	} else {
-		log.Printf("[LogParser] Configured file check: %v", err)
+		logger.Debug("Configured log file not found, searching its directory", "error", err)
 	}
-	
+
 	// Check if directory exists and is accessible
 	dirInfo, err := os.Stat(dir)
 	if os.IsNotExist(err) {
-		log.Printf("[LogParser] ERROR: Directory does not exist: %q", dir)
-		log.Printf("[LogParser] os.Stat error: %v (type: %T)", err, err)
-		
+		logger.Error("Log directory does not exist, check log_file_path in the config", "dir", dir, "log_file_path", logpath)
+
 		// Try to open directory to get more details
 		if f, openErr := os.Open(dir); openErr != nil {
-			log.Printf("[LogParser] os.Open error: %v (type: %T)", openErr, openErr)
+			logger.Debug("Log directory can't be opened", "error", openErr, "error_type", fmt.Sprintf("%T", openErr))
 		} else {
 			f.Close()
-			log.Printf("[LogParser] STRANGE: os.Open succeeded but os.Stat failed!")
+			logger.Debug("Log directory can be opened but not stat'ed")
 		}
-		
+
 		// Try parent directory
 		parent := filepath.Dir(dir)
 		if parentInfo, parentErr := os.Stat(parent); parentErr != nil {
-			log.Printf("[LogParser] Parent directory %q also not accessible: %v", parent, parentErr)
+			logger.Debug("Parent directory is not accessible either", "parent", parent, "error", parentErr)
 		} else {
-			log.Printf("[LogParser] Parent directory %q is accessible (permissions: %v)", parent, parentInfo.Mode())
+			logger.Debug("Parent directory is accessible", "parent", parent, "mode", parentInfo.Mode())
 		}
-		
-		log.Printf("[LogParser] Please check your log_file_path setting in config.toml")
-		log.Printf("[LogParser] The configured path was: %q", logpath)
-		log.Printf("[LogParser] Possible causes:")
-		log.Printf("[LogParser]   - Systemd sandboxing (PrivateTmp, ProtectHome, ProtectSystem, etc.)")
-		log.Printf("[LogParser]   - Mount namespace isolation")
-		log.Printf("[LogParser]   - Path doesn't exist in this process's view of filesystem")
+		logger.Debug("Possible causes are systemd sandboxing (PrivateTmp, ProtectHome, ProtectSystem, ...), mount namespace isolation, or a path that doesn't exist in this process's view of the filesystem")
 		return ""
 	} else if os.IsPermission(err) {
-		log.Printf("[LogParser] ERROR: Permission denied accessing directory: %q", dir)
-		log.Printf("[LogParser] The current user does not have permission to access this directory")
-		log.Printf("[LogParser] Please check file/directory permissions or run as a different user")
+		logger.Error("Permission denied accessing the log directory, check the permissions or run as a different user", "dir", dir)
 		return ""
 	} else if err != nil {
-		log.Printf("[LogParser] ERROR: Cannot access directory %q: %v", dir, err)
-		log.Printf("[LogParser] Error type: %T", err)
+		logger.Error("Cannot access the log directory", "dir", dir, "error", err, "error_type", fmt.Sprintf("%T", err))
 		return ""
 	}
-	
-	log.Printf("[LogParser] Directory accessible - Permissions: %v", dirInfo.Mode())
-	
+
+	logger.Debug("Log directory is accessible", "dir", dir, "mode", dirInfo.Mode())
+
 	prefix := dir + string(os.PathSeparator) + "log-Server"
-	log.Printf("[LogParser] Looking for files with prefix: %q", prefix)
-	
+	logger.Debug("Looking for log files", "prefix", prefix)
+
 	var file string
 	var modTime time.Time
 	fileCount := 0
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("[LogParser] Error walking path %q: %v", path, err)
+			logger.Debug("Error walking the log directory", "path", path, "error", err)
 			return nil
 		}
 		switch {
@@ -132,7 +80,7 @@ func findLogFile(logpath string) string {
 			return filepath.SkipDir
 		case strings.HasPrefix(path, prefix):
 			fileCount++
-			log.Printf("[LogParser] Found candidate log file: %q (modified: %v)", path, info.ModTime())
+			logger.Debug("Found candidate log file", "path", path, "modified", info.ModTime())
 			if info.ModTime().After(modTime) {
 				modTime = info.ModTime()
 				file = path
@@ -142,211 +90,218 @@ func findLogFile(logpath string) string {
 	})
 
 	if file == "" {
-		log.Printf("[LogParser] ERROR: No log files found matching prefix %q", prefix)
-		log.Printf("[LogParser] Searched in directory: %q", dir)
-		log.Printf("[LogParser] Files checked: %d", fileCount)
-		log.Printf("[LogParser] Make sure NS2 server log files exist and are readable")
+		logger.Error("No readable log files found, make sure the NS2 server log files exist", "dir", dir, "prefix", prefix, "files_checked", fileCount)
 	} else {
-		log.Printf("[LogParser] Selected log file: %q (most recent of %d files)", file, fileCount)
+		logger.Debug("Selected the most recent log file", "path", file, "candidates", fileCount)
 	}
 	return file
 }
 
-func startLogParser() {
-	for serverName, server := range serverList {
-		logfile := server.Config.LogFilePath
-		log.Printf("[LogParser] Starting log parser for server '%s'", serverName)
-		log.Printf("[LogParser] Configured log_file_path: %q", logfile)
-		
-		if logfile == "" {
-			log.Printf("[LogParser] ERROR: log_file_path not configured for server '%s'", serverName)
-			log.Printf("[LogParser] Please set log_file_path in your config.toml for this server")
-			continue
-		}
-		
-		currlog := findLogFile(logfile)
-		if currlog == "" {
-			log.Printf("[LogParser] ERROR: Could not find log file for server '%s'", serverName)
-			log.Printf("[LogParser] Possible reasons:")
-			log.Printf("[LogParser]   1. The log_file_path directory doesn't exist")
-			log.Printf("[LogParser]   2. No log files matching 'log-Server*' in that directory")
-			log.Printf("[LogParser]   3. Incorrect permissions to access the directory/files")
-			continue
-		}
-		
-		log.Printf("[LogParser] Monitoring log file: %s", currlog)
-		file, err := os.Open(currlog)
+// starts following the log file of the server, until stopLogTailer is called
+func (server *Server) startLogTailer() {
+	serverName := server.Name
+	logger := serverLogger("logparser", serverName)
+	logfile := server.Config().LogFilePath
+	logger.Debug("Starting log parser", "log_file_path", logfile, "field_separator", strings.ToUpper(fmt.Sprintf("%x", fieldSep)))
+
+	if logfile == "" && Config().HttpServer.Address != "" {
+		logger.Info("No log_file_path configured, expecting the events over HTTP")
+		return
+	}
+	if logfile == "" {
+		logger.Error("No log_file_path configured, set it in the config for this server")
+		return
+	}
+
+	currlog := findLogFile(logger, logfile)
+	if currlog == "" {
+		logger.Error("Could not find log file, the directory might not exist, contain no log-Server* files or not be accessible", "log_file_path", logfile)
+		return
+	}
+
+	logger.Info("Monitoring log file", "path", currlog)
+	file, err := os.Open(currlog)
+	if err != nil {
+		logger.Error("Failed to open log file", "path", currlog, "error", err)
+		return
+	}
+	server.stopTailer = make(chan struct{})
+	server.markTailerAlive()
+	go tailLogFile(serverName, server, currlog, file, server.stopTailer)
+}
+
+func (server *Server) stopLogTailer() {
+	if server.stopTailer != nil {
+		close(server.stopTailer)
+		server.stopTailer = nil
+		atomic.StoreInt64(&server.tailerAliveAt, 0)
+	}
+}
+
+// follows the log file of a server, waking up whenever the log directory changes
+func tailLogFile(serverName string, server *Server, currlog string, file *os.File, stop <-chan struct{}) {
+	logger := serverLogger("logparser", serverName)
+	watcher := newDirWatcher(filepath.Dir(currlog))
+	defer watcher.Close()
+	defer func() {
+		file.Close()
+		logger.Info("Stopped reading log file", "path", currlog)
+	}()
+	if watcher.polling {
+		logger.Info("Polling log file", "interval", dirPollInterval)
+	}
+
+	offset, maxAge := findLogStartOffset(serverName, file, currlog)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		logger.Warn("Could not seek to the start offset, starting at the end", "offset", offset, "error", err)
+		offset, _ = file.Seek(0, io.SeekEnd)
+	}
+	position, _ := logPositions.get(serverName)
+	position = position.update(file, currlog, offset)
+	reader := bufio.NewReader(file)
+
+	// lines that were written while the bridge was not running are only forwarded if they are recent enough
+	catchingUp := true
+	skipped := 0
+	logger.Debug("Ready to process new log entries")
+
+	var slept uint = 0
+	partial := ""
+	for {
+		server.markTailerAlive()
+		line, err := reader.ReadString('\n')
+
+		// --- 1. HANDLE ERRORS AND EOF ---
 		if err != nil {
-			log.Printf("[LogParser] ERROR: Failed to open log file '%s': %v", currlog, err)
-			continue
-		}
-		reader := bufio.NewReader(file)
-		go func(serverName string, server *Server) {
-			log.Printf("[LogParser] '%s': Skipping initial log content...", serverName)
-			for { // Skip the initial stuff; yes, this isn't the most efficient way
-				line, _ := reader.ReadString('\n')
-				if len(line) == 0 {
-					break
+			if err == io.EOF {
+				// End of file. This is normal.
+				// Keep an incomplete line until the rest of it is written.
+				partial += line
+				if len(line) > 0 {
+					slept = 0
+					continue
 				}
-			}
-			log.Printf("[LogParser] '%s': Ready to process new log entries", serverName)
-
-			var slept uint = 0
-			for {
-				line, err := reader.ReadString('\n')
-
-				// --- 1. HANDLE ERRORS AND EOF ---
-				if err != nil {
-					if err == io.EOF {
-						// End of file. This is normal.
-						// Wait a bit, then check for rotation.
-						slept += 1
-						time.Sleep(500 * time.Millisecond)
-
-						// Check if we've been idle long enough
-						if slept >= 5 {
-							slept = 0 // Reset counter
-
-							// Get the file info of the currently open file handle
-							// (This points to the *renamed* file)
-							oldstat, statErr := file.Stat()
-							if statErr != nil {
-								log.Printf("[LogParser] '%s': Error stat'ing current file handle: %v", serverName, statErr)
-								continue // Try again
-							}
 
-							// Check the file info at the *original configured path*
-							// (This points to the *new* file)
-							pathstat, pathErr := os.Stat(currlog)
-							if pathErr != nil {
-								log.Printf("[LogParser] '%s': Error stat'ing path %s: %v", serverName, currlog, pathErr)
-								continue // Try again
-							}
-
-							// If they are not the same file, it was rotated!
-							if !os.SameFile(oldstat, pathstat) {
-								log.Printf("[LogParser] '%s': Log file was rotated, switching to new file at %s", serverName, currlog)
-								newfile, openErr := os.Open(currlog)
-								if openErr != nil {
-									log.Printf("[LogParser] '%s': Error opening new log file: %v", serverName, openErr)
-									continue // Try again
-								}
-
-								// Close the old file (server.log.1)
-								file.Close() 
-								
-								// Start using the new file (server.log)
-								file = newfile
-								reader = bufio.NewReader(file)
-
-								// *** IMPORTANT ***
-								// We do NOT skip content. The new file is empty,
-								// and we want to read it from the beginning.
-								
-								log.Printf("[LogParser] '%s': Ready to process new log entries after rotation", serverName)
-								forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "init"}, "Server restarted/log rotated!", "", "")
-							}
-						}
-					} else {
-						// A real error, not just EOF
-						log.Printf("[LogParser] '%s': Error reading log file: %v", serverName, err)
-						time.Sleep(1 * time.Second) // Wait before retrying
+				if catchingUp {
+					catchingUp = false
+					if skipped > 0 {
+						logger.Info("Skipped old log lines", "count", skipped, "max_age", maxAge)
 					}
-					
-					// We had an error (EOF or other), so skip line processing
-					continue 
 				}
 
-				// --- 2. PROCESS A VALID LINE ---
-				// If we get here, err was nil and we have a line.
-				
-				slept = 0 // Reset the idle counter because we got data
+				// Wait for the next change in the log directory, then check for rotation.
+				if !watcher.wait(stop) {
+					return
+				}
+				slept += 1
+
+				// When polling, only check for rotation if we've been idle long enough
+				if watcher.polling && slept < 5 {
+					continue
+				}
+				slept = 0 // Reset counter
 
-				if len(line) == 0 {
-					continue // Skip empty lines that somehow had no error
+				// Get the file info of the currently open file handle
+				// (This points to the *renamed* file)
+				oldstat, statErr := file.Stat()
+				if statErr != nil {
+					logger.Warn("Error stat'ing current file handle", "error", statErr)
+					continue // Try again
 				}
-				
-				// (All your parsing logic below remains unchanged)
-
-				// Check if line contains DISCORD marker
-				if strings.Contains(line, "--DISCORD--") {
-					log.Printf("[LogParser] '%s': Found DISCORD line: %q", serverName, line)
-					
-					// Show the line with visible separators for debugging
-					visibleLine := strings.ReplaceAll(line, "\x1e", "[SEP]")
-					log.Printf("[LogParser] '%s': Line with visible separators: %q", serverName, visibleLine)
+
+				// Check the file info at the *original configured path*
+				// (This points to the *new* file)
+				pathstat, pathErr := os.Stat(currlog)
+				if pathErr != nil {
+					// the file might be renamed but not yet recreated
+					continue // Try again
 				}
-				
-				if matches := chatRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched CHAT message - Name: %q, SteamID: %q, Team: %q, Message: %q", 
-						serverName, matches[1], matches[2], matches[3], matches[4])
-					steamid, _ := strconv.ParseInt(matches[2], 10, 32)
-					teamNumber, _ := strconv.Atoi(matches[3])
-					log.Printf("[LogParser] '%s': Forwarding chat message to Discord...", serverName)
-					forwardChatMessageToDiscord(server, matches[1], SteamID3(steamid), TeamNumber(teamNumber), matches[4])
-				} else if matches := statusRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched STATUS message - State: %q, Map: %q, Players: %q", 
-						serverName, matches[1], matches[2], matches[3])
-					gamestate := matches[1]
-					currmap := matches[2]
-					players := matches[3]
-					var message string
-					var msgtype MessageType
-					msgtype.GroupType = "status"
-					switch gamestate {
-					case "Started":
-						message = "Round started on "
-						msgtype.SubType = "roundstart"
-					case "Team1Won":
-						message = "Marines won on "
-						msgtype.SubType = "marinewin"
-					case "Team2Won":
-						message = "Aliens won on "
-						msgtype.SubType = "alienwin"
-					case "Draw":
-						message = "Draw on "
-						msgtype.SubType = "draw"
-					default:
-						continue
+
+				// If they are not the same file, it was rotated!
+				if !os.SameFile(oldstat, pathstat) {
+					// drain whatever was written to the old file before it was renamed
+					if rest, _ := ioutil.ReadAll(reader); len(rest) > 0 {
+						for _, line := range strings.SplitAfter(partial+string(rest), "\n") {
+							if strings.HasSuffix(line, "\n") {
+								processLogLine(serverName, server, line)
+							}
+						}
 					}
-					log.Printf("[LogParser] '%s': Forwarding status message to Discord: %s", serverName, message+currmap)
-					forwardStatusMessageToDiscord(server, msgtype, message, players, currmap)
-				} else if matches := changemapRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched CHANGEMAP - Map: %q, Players: %q", 
-						serverName, matches[1], matches[2])
-					nextmap := matches[1]
-					players := matches[2]
-					message := "Changing map to "
-					log.Printf("[LogParser] '%s': Forwarding changemap to Discord", serverName)
-					forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "changemap"}, message, players, nextmap)
-				} else if matches := initRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched INIT - Map: %q", serverName, matches[1])
-					currmap := matches[1]
-					message := "Loaded "
-					log.Printf("[LogParser] '%s': Forwarding init to Discord", serverName)
-					forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "init"}, message, "", currmap)
-				} else if matches := playerRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched PLAYER event - Action: %q, Name: %q, SteamID: %q, Players: %q", 
-						serverName, matches[1], matches[2], matches[3], matches[4])
-					action := matches[1]
-					name := matches[2]
-					steamid, _ := strconv.ParseInt(matches[3], 10, 32)
-					players := matches[4]
-					msgtype := MessageType{
-						GroupType: "player",
-						SubType:   action,
+					partial = ""
+
+					logger.Info("Log file was rotated, switching to the new file", "path", currlog)
+					newfile, openErr := os.Open(currlog)
+					if openErr != nil {
+						logger.Warn("Error opening new log file", "path", currlog, "error", openErr)
+						continue // Try again
 					}
-					log.Printf("[LogParser] '%s': Forwarding player event to Discord", serverName)
-					forwardPlayerEventToDiscord(server, msgtype, name, SteamID3(steamid), players)
-				} else if matches := adminprintRegexp.FindStringSubmatch(line); matches != nil {
-					log.Printf("[LogParser] '%s': Matched ADMINPRINT - Message: %q", serverName, matches[1])
-					log.Printf("[LogParser] '%s': Forwarding adminprint to Discord", serverName)
-					forwardStatusMessageToDiscord(server, MessageType{GroupType: "adminprint"}, matches[1], "", "")
-				} else if strings.Contains(line, "--DISCORD--") {
-					log.Printf("[LogParser] '%s': WARNING - DISCORD line did not match any pattern!", serverName)
-					log.Printf("[LogParser] '%s': Regex patterns expecting separator: %q", serverName, fieldSep)
+
+					// Close the old file (server.log.1)
+					file.Close()
+
+					// Start using the new file (server.log)
+					file = newfile
+					reader = bufio.NewReader(file)
+					offset = 0
+					position = LogPosition{}.update(file, currlog, offset)
+					logPositions.set(serverName, position)
+
+					// *** IMPORTANT ***
+					// We do NOT skip content. The new file is empty,
+					// and we want to read it from the beginning.
+
+					logger.Debug("Ready to process new log entries after rotation")
+					forwardStatusMessageToDiscord(server, MessageType{GroupType: "status", SubType: "init"}, "Server restarted/log rotated!", "", "")
 				}
-			} // end for
-		}(serverName, server)
+			} else {
+				// A real error, not just EOF
+				logger.Error("Error reading log file", "path", currlog, "error", err)
+				time.Sleep(1 * time.Second) // Wait before retrying
+			}
+
+			// We had an error (EOF or other), so skip line processing
+			continue
+		}
+
+		// --- 2. PROCESS A VALID LINE ---
+		// If we get here, err was nil and we have a line.
+
+		slept = 0 // Reset the idle counter because we got data
+
+		line = partial + line
+		partial = ""
+		offset += int64(len(line))
+		position = position.update(file, currlog, offset)
+		logPositions.set(serverName, position)
+
+		if catchingUp {
+			if age, ok := logLineAge(line, time.Now()); ok && age > maxAge {
+				skipped++
+				continue
+			}
+		}
+		processLogLine(serverName, server, line)
+	}
+}
+
+// parses a single line of the log file and publishes the event it contains
+func processLogLine(serverName string, server *Server, line string) {
+	server.captureLogLine(line)
+	if !strings.Contains(line, logEventMarker) {
+		return
+	}
+	logger := serverLogger("logparser", serverName)
+	logger.Debug("Found DISCORD line", "line", line)
+
+	event, ok := parseLogLine(line)
+	if !ok {
+		// Show the line with visible separators for debugging
+		visibleLine := strings.ReplaceAll(line, fieldSep, "[SEP]")
+		logger.Warn("DISCORD line did not match any pattern", "line", visibleLine, "separator", fieldSep)
+		atomic.AddUint64(&metrics.server(serverName).UnmatchedLines, 1)
+		return
 	}
+	atomic.AddUint64(&metrics.server(serverName).ParsedLines, 1)
+	logger.Debug("Matched event", "kind", event.Kind(), "event", fmt.Sprintf("%+v", event))
+	eventBus.publish(server, event)
 }
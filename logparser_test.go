@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passes the events of a server to the returned channel, the subscription stays but ignores other servers
+func collectEvents(server *Server) <-chan LogEvent {
+	events := make(chan LogEvent, 100)
+	eventBus.subscribe(func(s *Server, event LogEvent) {
+		if s == server {
+			events <- event
+		}
+	})
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan LogEvent) LogEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(15 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// a chat line that was written the given time ago
+func chatLogLine(age time.Duration, message string) string {
+	return strings.Replace(discordLogLine("chat", "Brute", "12345", "1", message), "[12:34:56]", time.Now().Add(-age).Format("[15:04:05]"), 1)
+}
+
+/* waits until the chat line was posted to Discord, so the channel queue is idle when the test ends.
+ * consecutive lines of a player are grouped into one message, so the line may arrive as part of an edit
+ */
+func expectPostedLine(t *testing.T, fake *FakeDiscordSession, text string) {
+	t.Helper()
+	for {
+		message := fake.nextMessage(t)
+		if len(message.Embeds) == 1 && strings.HasSuffix(message.Embeds[0].Description, text) {
+			return
+		}
+	}
+}
+
+func appendToFile(t *testing.T, path string, text string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogTailer(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "log-Server.txt")
+	appendToFile(t, logFile, chatLogLine(2*time.Hour, "too old")+chatLogLine(time.Minute, "recent"))
+	fake, server := newTestBridge(t, "210", ServerConfig{LogFilePath: logFile})
+	config := *Config()
+	config.LogParser.StartPolicy = "replay"
+	setConfig(&config)
+	events := collectEvents(server)
+
+	server.startLogTailer()
+	defer server.stopLogTailer()
+
+	// lines that are older than the replayed time are skipped
+	if event, _ := nextEvent(t, events).(ChatEvent); event.Message != "recent" {
+		t.Errorf("expected the recent line, got %+v", event)
+	}
+	expectPostedLine(t, fake, "recent")
+
+	// incomplete lines are kept until the rest is written
+	line := chatLogLine(0, "hello")
+	appendToFile(t, logFile, line[:len(line)-4])
+	time.Sleep(200 * time.Millisecond)
+	appendToFile(t, logFile, line[len(line)-4:])
+	if event, _ := nextEvent(t, events).(ChatEvent); event.Message != "hello" {
+		t.Errorf("expected the completed line, got %+v", event)
+	}
+	expectPostedLine(t, fake, "hello")
+
+	// after a rotation, the rest of the old file is read before the new one
+	rotated := filepath.Join(filepath.Dir(logFile), "log-Server.1.txt")
+	if err := os.Rename(logFile, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendToFile(t, rotated, chatLogLine(0, "last of the old file"))
+	appendToFile(t, logFile, chatLogLine(0, "first of the new file"))
+	for _, expected := range []string{"last of the old file", "first of the new file"} {
+		if event, _ := nextEvent(t, events).(ChatEvent); event.Message != expected {
+			t.Errorf("expected %q, got %+v", expected, event)
+		}
+	}
+	expectPostedLine(t, fake, "first of the new file")
+
+	if position, _ := logPositions.get(server.Name); position.File != logFile || position.Offset != int64(len(chatLogLine(0, "first of the new file"))) {
+		t.Errorf("unexpected log position %+v", position)
+	}
+}
@@ -0,0 +1,198 @@
+// This file keeps track of how far the log file of each server has been read.
+// The positions are stored in a state file, so the log parser can pick up where it stopped after a restart
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logPositionsFile         = "logpositions.json"
+	logPositionsSaveInterval = 5 * time.Second
+	// number of bytes at the start of a log file that identify it
+	logFingerprintSize = 1024
+
+	defaultReplayMinutes     = 5
+	defaultMaxBacklogMinutes = 15
+)
+
+type LogPosition struct {
+	File     string    `json:"file"`
+	HeadSize int64     `json:"headSize"`
+	HeadHash string    `json:"headHash"`
+	Offset   int64     `json:"offset"`
+	Updated  time.Time `json:"updated"`
+}
+
+type LogPositionStore struct {
+	sync.Mutex
+	positions map[string]LogPosition
+	dirty     bool
+}
+
+var (
+	logPositions        = &LogPositionStore{positions: make(map[string]LogPosition)}
+	logTimestampPattern = regexp.MustCompile(`^\[([0-9][0-9]):([0-9][0-9]):([0-9][0-9])\]`)
+)
+
+func (store *LogPositionStore) load() {
+	store.Lock()
+	defer store.Unlock()
+	path := dataFilePath(logPositionsFile)
+	if err := loadJSONFile(path, &store.positions); err != nil && !os.IsNotExist(err) {
+		subsystemLogger("logparser").Error("Could not read log positions", "path", path, "error", err)
+	}
+	if store.positions == nil {
+		store.positions = make(map[string]LogPosition)
+	}
+}
+
+func (store *LogPositionStore) get(serverName string) (LogPosition, bool) {
+	store.Lock()
+	defer store.Unlock()
+	position, ok := store.positions[serverName]
+	return position, ok
+}
+
+func (store *LogPositionStore) set(serverName string, position LogPosition) {
+	store.Lock()
+	defer store.Unlock()
+	position.Updated = time.Now()
+	store.positions[serverName] = position
+	store.dirty = true
+}
+
+// writes the positions to disk if they changed since the last save
+func (store *LogPositionStore) save() {
+	store.Lock()
+	defer store.Unlock()
+	if !store.dirty {
+		return
+	}
+	path := dataFilePath(logPositionsFile)
+	if err := saveJSONFile(path, store.positions); err != nil {
+		subsystemLogger("logparser").Error("Could not save log positions", "path", path, "error", err)
+		return
+	}
+	store.dirty = false
+}
+
+func (store *LogPositionStore) startSaving() {
+	go func() {
+		for range time.Tick(logPositionsSaveInterval) {
+			store.save()
+		}
+	}()
+}
+
+// hashes the first bytes of a file, which identify it even after it was renamed by a log rotation
+func fingerprintLogFile(file *os.File, size int64) (int64, string) {
+	if size > logFingerprintSize {
+		size = logFingerprintSize
+	}
+	head := make([]byte, size)
+	n, _ := file.ReadAt(head, 0)
+	sum := sha1.Sum(head[:n])
+	return int64(n), hex.EncodeToString(sum[:])
+}
+
+// creates a position for the given file, the fingerprint is only updated while the file is still short
+func (position LogPosition) update(file *os.File, path string, offset int64) LogPosition {
+	if position.File != path || position.HeadSize < logFingerprintSize {
+		position.HeadSize, position.HeadHash = fingerprintLogFile(file, offset)
+	}
+	position.File = path
+	position.Offset = offset
+	return position
+}
+
+// checks whether the stored position belongs to the given file
+func (position LogPosition) matches(file *os.File, path string) bool {
+	info, err := file.Stat()
+	if err != nil || position.File != path || position.HeadSize == 0 || info.Size() < position.Offset {
+		return false
+	}
+	size, hash := fingerprintLogFile(file, position.HeadSize)
+	return size == position.HeadSize && hash == position.HeadHash
+}
+
+/* decides where to start reading a log file, based on the configured start policy
+ * returns the offset and the maximum age of lines that are still forwarded to Discord
+ */
+func findLogStartOffset(serverName string, file *os.File, path string) (int64, time.Duration) {
+	maxBacklog := time.Duration(Config().LogParser.MaxBacklogMinutes) * time.Minute
+	if maxBacklog <= 0 {
+		maxBacklog = defaultMaxBacklogMinutes * time.Minute
+	}
+	replay := time.Duration(Config().LogParser.ReplayMinutes) * time.Minute
+	if replay <= 0 {
+		replay = defaultReplayMinutes * time.Minute
+	}
+	if replay > maxBacklog {
+		replay = maxBacklog
+	}
+
+	logger := serverLogger("logparser", serverName)
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		logger.Warn("Could not determine size of log file", "path", path, "error", err)
+		return 0, maxBacklog
+	}
+
+	switch Config().LogParser.StartPolicy {
+	case "end":
+		logger.Info("Skipping initial log content")
+		return end, maxBacklog
+	case "replay":
+		logger.Info("Replaying the end of the log", "duration", replay)
+		return 0, replay
+	default:
+		fallthrough
+	case "resume":
+		position, ok := logPositions.get(serverName)
+		switch {
+		case !ok:
+			logger.Info("No stored log position, skipping initial log content")
+			return end, maxBacklog
+		case position.matches(file, path):
+			logger.Info("Resuming log", "offset", position.Offset, "bytes_behind", end-position.Offset)
+			return position.Offset, maxBacklog
+		default:
+			logger.Info("Log file changed since the last run, reading it from the start")
+			return 0, maxBacklog
+		}
+	}
+}
+
+/* returns how long ago a log line was written, based on its [hh:mm:ss] timestamp
+ * the log only contains the time of day, so lines are assumed to be less than a day old
+ */
+func logLineAge(line string, now time.Time) (time.Duration, bool) {
+	matches := logTimestampPattern.FindStringSubmatch(line)
+	if matches == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(matches[1])
+	minutes, _ := strconv.Atoi(matches[2])
+	seconds, _ := strconv.Atoi(matches[3])
+	written := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	current := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	age := current - written
+	switch {
+	case age < -time.Minute:
+		// written before midnight
+		age += 24 * time.Hour
+	case age < 0:
+		// clocks are slightly off
+		age = 0
+	}
+	return age, true
+}
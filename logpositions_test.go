@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLineAge(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 30, 0, time.Local)
+	tests := []struct {
+		name string
+		line string
+		now  time.Time
+		age  time.Duration
+		ok   bool
+	}{
+		{"same minute", "[00:00:10]line", now, 20 * time.Second, true},
+		{"earlier today", "[12:00:00]line", now.Add(13 * time.Hour), time.Hour + 30*time.Second, true},
+		{"before midnight", "[23:59:50]line", now, 40 * time.Second, true},
+		{"hours before midnight", "[22:00:30]line", now, 2 * time.Hour, true},
+		{"clock of the server slightly ahead", "[00:00:50]line", now, 0, true},
+		{"clock of the server a minute ahead is taken as yesterday", "[00:02:00]line", now, 24*time.Hour - 90*time.Second, true},
+		{"no timestamp", "line", now, 0, false},
+		{"timestamp not at the start", "line [00:00:10]", now, 0, false},
+	}
+	for _, test := range tests {
+		age, ok := logLineAge(test.line, test.now)
+		if age != test.age || ok != test.ok {
+			t.Errorf("%s: logLineAge(%q) = %v, %v, want %v, %v", test.name, test.line, age, ok, test.age, test.ok)
+		}
+	}
+}
+
+func writeLogFile(t *testing.T, path string, content string) *os.File {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestLogPositionMatches(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log-Server.txt")
+	long := strings.Repeat("[12:00:00]a line of the old log\n", 100)
+	short := "[12:00:00]first line\n"
+
+	tests := []struct {
+		name     string
+		stored   string
+		offset   int64
+		current  string
+		moved    bool
+		expected bool
+	}{
+		{"file grew", long, 2000, long + "more\n", false, true},
+		{"short file grew", short, int64(len(short)), short + "more\n", false, true},
+		{"nothing new", long, int64(len(long)), long, false, true},
+		{"rotated, new file with another head", long, 2000, strings.Replace(long, "old", "new", -1), false, false},
+		{"truncated", long, 2000, long[:1000], false, false},
+		{"rotated file has another name", long, 2000, long, true, false},
+	}
+	for _, test := range tests {
+		stored := writeLogFile(t, path, test.stored)
+		position := LogPosition{}.update(stored, path, test.offset)
+		stored.Close()
+
+		current := writeLogFile(t, path, test.current)
+		if test.moved {
+			position.File = filepath.Join(dir, "log-Server.1.txt")
+		}
+		if matches := position.matches(current, path); matches != test.expected {
+			t.Errorf("%s: matches = %v, want %v", test.name, matches, test.expected)
+		}
+	}
+}
+
+func TestLogPositionFingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log-Server.txt")
+	short := "[12:00:00]first line\n"
+	file := writeLogFile(t, path, short)
+	position := LogPosition{}.update(file, path, int64(len(short)))
+	if position.HeadSize != int64(len(short)) {
+		t.Fatalf("expected the fingerprint to cover the whole short file, got %d bytes", position.HeadSize)
+	}
+
+	// while the file is shorter than the fingerprint, the fingerprint grows with it
+	long := short + strings.Repeat("[12:00:01]another line\n", 100)
+	file = writeLogFile(t, path, long)
+	position = position.update(file, path, int64(len(long)))
+	if position.HeadSize != logFingerprintSize || position.Offset != int64(len(long)) {
+		t.Fatalf("expected a full fingerprint, got %+v", position)
+	}
+
+	// afterwards it stays the same
+	hash := position.HeadHash
+	file = writeLogFile(t, path, long+"more\n")
+	if position = position.update(file, path, int64(len(long)+5)); position.HeadHash != hash {
+		t.Errorf("expected the fingerprint to stay the same")
+	}
+}
+
+func TestFindLogStartOffsetAfterRotation(t *testing.T) {
+	useConfig(t, &Configuration{})
+
+	path := filepath.Join(t.TempDir(), "log-Server.txt")
+	old := strings.Repeat("[12:00:00]old log\n", 10)
+	file := writeLogFile(t, path, old+"unread\n")
+	logPositions.set("positions-test", LogPosition{}.update(file, path, int64(len(old))))
+	defer func() {
+		logPositions.Lock()
+		delete(logPositions.positions, "positions-test")
+		logPositions.Unlock()
+	}()
+
+	if offset, _ := findLogStartOffset("positions-test", file, path); offset != int64(len(old)) {
+		t.Errorf("expected to resume at %d, got %d", len(old), offset)
+	}
+
+	// the server rotated the log while the bridge was not running
+	file = writeLogFile(t, path, "[13:00:00]new log\n")
+	if offset, _ := findLogStartOffset("positions-test", file, path); offset != 0 {
+		t.Errorf("expected to read the new log from the start, got %d", offset)
+	}
+}
@@ -0,0 +1,241 @@
+// This file contains the optional monitoring endpoints of the bridge.
+// /metrics exposes counters and gauges per server in the Prometheus text format, /healthz fails when the
+// Discord session is down or a log parser stopped reading, so the bridge can be restarted by a supervisor
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// the log parser wakes up at least every dirWatchTimeout, so it is stalled if it did not for much longer
+const logTailerStallTimeout = time.Minute
+
+type ServerMetrics struct {
+	ParsedLines      uint64
+	UnmatchedLines   uint64
+	MessagesSent     uint64
+	DiscordErrors    uint64
+	WebAdminFailures uint64
+	Players          int64
+}
+
+type Metrics struct {
+	sync.Mutex
+	servers map[string]*ServerMetrics
+	// 1 while the gateway connection of the Discord session is up
+	discordConnected int32
+}
+
+var metrics = &Metrics{servers: make(map[string]*ServerMetrics)}
+
+func init() {
+	eventBus.subscribe(updatePlayerMetrics)
+}
+
+func startMetricsServer() {
+	address := Config().Metrics.Address
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+
+	go func() {
+		subsystemLogger("metrics").Info("Listening", "address", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			subsystemLogger("metrics").Error("Metrics server stopped", "error", err)
+		}
+	}()
+}
+
+// returns the metrics of a server, they are created on first use
+func (metrics *Metrics) server(serverName string) *ServerMetrics {
+	metrics.Lock()
+	defer metrics.Unlock()
+	serverMetrics, ok := metrics.servers[serverName]
+	if !ok {
+		serverMetrics = &ServerMetrics{}
+		metrics.servers[serverName] = serverMetrics
+	}
+	return serverMetrics
+}
+
+// returns the metrics of the server that a channel belongs to, messages to other channels are counted without server
+func (metrics *Metrics) channel(channelID string) *ServerMetrics {
+	for _, server := range serverList.all() {
+		if server.Config().ChannelID == channelID || server.Config().StatusChannelID == channelID {
+			return metrics.server(server.Name)
+		}
+	}
+	return metrics.server("")
+}
+
+func (metrics *Metrics) setDiscordConnected(connected bool) {
+	var value int32
+	if connected {
+		value = 1
+	}
+	atomic.StoreInt32(&metrics.discordConnected, value)
+}
+
+func (metrics *Metrics) isDiscordConnected() bool {
+	return atomic.LoadInt32(&metrics.discordConnected) == 1
+}
+
+func discordConnectHandler(s *discordgo.Session, c *discordgo.Connect) {
+	metrics.setDiscordConnected(true)
+}
+
+func discordDisconnectHandler(s *discordgo.Session, d *discordgo.Disconnect) {
+	subsystemLogger("discord").Warn("Disconnected from Discord")
+	metrics.setDiscordConnected(false)
+}
+
+// keeps the player count of the servers up to date
+func updatePlayerMetrics(server *Server, event LogEvent) {
+	var playerCount string
+	switch event := event.(type) {
+	case PlayerEvent:
+		playerCount = event.PlayerCount
+	case StatusEvent:
+		playerCount = event.PlayerCount
+	case ChangeMapEvent:
+		playerCount = event.PlayerCount
+	default:
+		return
+	}
+	if players, err := strconv.Atoi(strings.SplitN(playerCount, "/", 2)[0]); err == nil {
+		atomic.StoreInt64(&metrics.server(server.Name).Players, int64(players))
+	}
+}
+
+// returns how many bytes of the log file of a server have not been read yet
+func logLagBytes(serverName string) (int64, bool) {
+	position, ok := logPositions.get(serverName)
+	if !ok || position.File == "" {
+		return 0, false
+	}
+	info, err := os.Stat(position.File)
+	if err != nil {
+		return 0, false
+	}
+	if lag := info.Size() - position.Offset; lag > 0 {
+		return lag, true
+	}
+	return 0, true
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Lock()
+	serverNames := make([]string, 0, len(metrics.servers))
+	for serverName := range metrics.servers {
+		serverNames = append(serverNames, serverName)
+	}
+	metrics.Unlock()
+	for _, server := range serverList.all() {
+		metrics.server(server.Name)
+		serverNames = append(serverNames, server.Name)
+	}
+	sort.Strings(serverNames)
+	serverNames = uniqueStrings(serverNames)
+
+	var out strings.Builder
+	writeMetric := func(name string, metricType string, help string, value func(serverName string, serverMetrics *ServerMetrics) (string, bool)) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		for _, serverName := range serverNames {
+			if text, ok := value(serverName, metrics.server(serverName)); ok {
+				fmt.Fprintf(&out, "%s{server=%q} %s\n", name, serverName, text)
+			}
+		}
+	}
+	counter := func(field func(*ServerMetrics) *uint64) func(string, *ServerMetrics) (string, bool) {
+		return func(serverName string, serverMetrics *ServerMetrics) (string, bool) {
+			return strconv.FormatUint(atomic.LoadUint64(field(serverMetrics)), 10), true
+		}
+	}
+
+	writeMetric("ns2bridge_log_lines_parsed_total", "counter", "Log lines that were parsed into events.",
+		counter(func(m *ServerMetrics) *uint64 { return &m.ParsedLines }))
+	writeMetric("ns2bridge_log_lines_unmatched_total", "counter", "--DISCORD-- log lines that did not match any event.",
+		counter(func(m *ServerMetrics) *uint64 { return &m.UnmatchedLines }))
+	writeMetric("ns2bridge_discord_messages_sent_total", "counter", "Messages sent or edited in Discord.",
+		counter(func(m *ServerMetrics) *uint64 { return &m.MessagesSent }))
+	writeMetric("ns2bridge_discord_api_errors_total", "counter", "Failed requests to the Discord API.",
+		counter(func(m *ServerMetrics) *uint64 { return &m.DiscordErrors }))
+	writeMetric("ns2bridge_webadmin_failures_total", "counter", "Failed requests to web admin.",
+		counter(func(m *ServerMetrics) *uint64 { return &m.WebAdminFailures }))
+	writeMetric("ns2bridge_players", "gauge", "Players on the server, as of the last event.",
+		func(serverName string, serverMetrics *ServerMetrics) (string, bool) {
+			return strconv.FormatInt(atomic.LoadInt64(&serverMetrics.Players), 10), serverName != ""
+		})
+	writeMetric("ns2bridge_log_lag_bytes", "gauge", "Bytes of the log file that were not read yet.",
+		func(serverName string, serverMetrics *ServerMetrics) (string, bool) {
+			lag, ok := logLagBytes(serverName)
+			return strconv.FormatInt(lag, 10), ok
+		})
+
+	connected := 0
+	if metrics.isDiscordConnected() {
+		connected = 1
+	}
+	fmt.Fprintf(&out, "# HELP ns2bridge_discord_connected Whether the gateway connection to Discord is up.\n")
+	fmt.Fprintf(&out, "# TYPE ns2bridge_discord_connected gauge\nns2bridge_discord_connected %d\n", connected)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(out.String()))
+}
+
+// lists everything that is wrong with the bridge, an empty list means it is healthy
+func healthProblems() []string {
+	var problems []string
+	if !metrics.isDiscordConnected() {
+		problems = append(problems, "discord: session is not connected")
+	}
+	for _, server := range serverList.all() {
+		if server.Config().LogFilePath == "" {
+			continue
+		}
+		alive := server.tailerAlive()
+		switch {
+		case alive.IsZero():
+			problems = append(problems, "server '"+server.Name+"': log parser is not running")
+		case time.Since(alive) > logTailerStallTimeout:
+			problems = append(problems, "server '"+server.Name+"': log parser stalled "+formatDuration(time.Since(alive))+" ago")
+		}
+	}
+	return problems
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if problems := healthProblems(); len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// removes repeated values from a sorted list
+func uniqueStrings(values []string) []string {
+	unique := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
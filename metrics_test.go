@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useDiscordConnected(t *testing.T, connected bool) {
+	previous := metrics.isDiscordConnected()
+	metrics.setDiscordConnected(connected)
+	t.Cleanup(func() { metrics.setDiscordConnected(previous) })
+}
+
+func TestMetricsExposition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log-Server.txt")
+	_, server := newTestBridge(t, "272", ServerConfig{LogFilePath: path})
+	useDiscordConnected(t, true)
+	t.Cleanup(func() {
+		metrics.Lock()
+		delete(metrics.servers, server.Name)
+		metrics.Unlock()
+		logPositions.Lock()
+		delete(logPositions.positions, server.Name)
+		logPositions.Unlock()
+	})
+
+	atomic.AddUint64(&metrics.server(server.Name).WebAdminFailures, 2)
+	updatePlayerMetrics(server, PlayerEvent{Name: "Brute", Action: "join", PlayerCount: "12/24"})
+	file := writeLogFile(t, path, "[12:00:00]read\n[12:00:01]unread\n")
+	logPositions.set(server.Name, LogPosition{}.update(file, path, int64(len("[12:00:00]read\n"))))
+
+	response := httptest.NewRecorder()
+	metricsHandler(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := response.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	body := response.Body.String()
+	for _, expected := range []string{
+		"# TYPE ns2bridge_webadmin_failures_total counter\n",
+		`ns2bridge_webadmin_failures_total{server="test-272"} 2` + "\n",
+		`ns2bridge_log_lines_parsed_total{server="test-272"} 0` + "\n",
+		"# TYPE ns2bridge_players gauge\n",
+		`ns2bridge_players{server="test-272"} 12` + "\n",
+		`ns2bridge_log_lag_bytes{server="test-272"} 17` + "\n",
+		"ns2bridge_discord_connected 1\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the metrics, got\n%s", expected, body)
+		}
+	}
+}
+
+func TestHealthOfStalledTailer(t *testing.T) {
+	_, server := newTestBridge(t, "273", ServerConfig{LogFilePath: filepath.Join(t.TempDir(), "log-Server.txt")})
+	useDiscordConnected(t, true)
+
+	tests := []struct {
+		name   string
+		alive  time.Time
+		status int
+		body   string
+	}{
+		{"not running", time.Time{}, http.StatusServiceUnavailable, "server 'test-273': log parser is not running\n"},
+		{"stalled", time.Now().Add(-2 * logTailerStallTimeout), http.StatusServiceUnavailable, "server 'test-273': log parser stalled 2m ago\n"},
+		{"healthy", time.Now(), http.StatusOK, "ok\n"},
+	}
+	for _, test := range tests {
+		var alive int64
+		if !test.alive.IsZero() {
+			alive = test.alive.UnixNano()
+		}
+		atomic.StoreInt64(&server.tailerAliveAt, alive)
+		response := httptest.NewRecorder()
+		healthHandler(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if response.Code != test.status || response.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, response.Code, response.Body.String(), test.status, test.body)
+		}
+	}
+
+	metrics.setDiscordConnected(false)
+	response := httptest.NewRecorder()
+	healthHandler(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "discord: session is not connected") {
+		t.Errorf("expected the disconnected session to fail the health check, got %d %q", response.Code, response.Body.String())
+	}
+}
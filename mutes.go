@@ -0,0 +1,205 @@
+// This file contains the mutes that admins set with the mute command.
+// They are stored in a state file per server, so they survive a restart of the bot, and may expire after a while.
+// Users in the muted list of the config are muted permanently and are not part of this
+
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	mutesFile         = "mutes.json"
+	muteSweepInterval = 30 * time.Second
+	// longer durations are rejected, so adding them to the current time can't overflow
+	maxMuteDuration = 10 * 365 * 24 * time.Hour
+)
+
+type Mute struct {
+	UserID    string    `json:"userID"`
+	UserName  string    `json:"userName"`
+	MutedBy   string    `json:"mutedBy"`
+	MutedByID string    `json:"mutedByID"`
+	MutedAt   time.Time `json:"mutedAt"`
+	Reason    string    `json:"reason,omitempty"`
+	// zero for mutes that don't expire
+	Expires time.Time `json:"expires"`
+}
+
+type MuteStore struct {
+	sync.Mutex
+	// mutes by server name
+	mutes map[string][]Mute
+}
+
+var (
+	muteStore           = &MuteStore{mutes: make(map[string][]Mute)}
+	muteDurationPattern = regexp.MustCompile(`^([0-9]+[smhdw])+$`)
+	muteDurationPart    = regexp.MustCompile(`([0-9]+)([smhdw])`)
+)
+
+func (mute Mute) isExpired(now time.Time) bool {
+	return !mute.Expires.IsZero() && now.After(mute.Expires)
+}
+
+func (store *MuteStore) load() {
+	store.Lock()
+	defer store.Unlock()
+	path := dataFilePath(mutesFile)
+	if err := loadJSONFile(path, &store.mutes); err != nil && !os.IsNotExist(err) {
+		subsystemLogger("mutes").Error("Could not read mutes", "path", path, "error", err)
+	}
+	if store.mutes == nil {
+		store.mutes = make(map[string][]Mute)
+	}
+}
+
+// has to be called with the lock held
+func (store *MuteStore) save() {
+	path := dataFilePath(mutesFile)
+	if err := saveJSONFile(path, store.mutes); err != nil {
+		subsystemLogger("mutes").Error("Could not save mutes", "path", path, "error", err)
+	}
+}
+
+// adds or replaces the mute of a user, returns false if the user was muted already
+func (store *MuteStore) add(serverName string, mute Mute) bool {
+	store.Lock()
+	defer store.Unlock()
+	mutes := store.mutes[serverName]
+	for i, existing := range mutes {
+		if existing.UserID == mute.UserID {
+			mutes[i] = mute
+			store.save()
+			return false
+		}
+	}
+	store.mutes[serverName] = append(mutes, mute)
+	store.save()
+	return true
+}
+
+func (store *MuteStore) remove(serverName string, userID string) bool {
+	store.Lock()
+	defer store.Unlock()
+	mutes := store.mutes[serverName]
+	for i, mute := range mutes {
+		if mute.UserID == userID {
+			store.mutes[serverName] = append(mutes[:i:i], mutes[i+1:]...)
+			store.save()
+			return true
+		}
+	}
+	return false
+}
+
+// returns the mutes of a server that did not expire yet
+func (store *MuteStore) list(serverName string) []Mute {
+	store.Lock()
+	defer store.Unlock()
+	now := time.Now()
+	active := make([]Mute, 0)
+	for _, mute := range store.mutes[serverName] {
+		if !mute.isExpired(now) {
+			active = append(active, mute)
+		}
+	}
+	return active
+}
+
+func (store *MuteStore) isMuted(serverName string, member *discordgo.Member) bool {
+	for _, mute := range store.list(serverName) {
+		if mute.UserID == member.User.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// removes expired mutes
+func (store *MuteStore) expire() {
+	store.Lock()
+	defer store.Unlock()
+	now := time.Now()
+	changed := false
+	for serverName, mutes := range store.mutes {
+		active := make([]Mute, 0, len(mutes))
+		for _, mute := range mutes {
+			if mute.isExpired(now) {
+				serverLogger("mutes", serverName).Info("Mute expired", "user", mute.UserName, "user_id", mute.UserID)
+				changed = true
+				continue
+			}
+			active = append(active, mute)
+		}
+		store.mutes[serverName] = active
+	}
+	if changed {
+		store.save()
+	}
+}
+
+func (store *MuteStore) startExpiring() {
+	go func() {
+		for range time.Tick(muteSweepInterval) {
+			store.expire()
+		}
+	}()
+}
+
+/* parses durations like 30m, 2h, 7d or 1d12h
+ * in addition to the units of time.ParseDuration, days (d) and weeks (w) are supported. durations of more than 10 years
+ * are not valid
+ */
+func parseMuteDuration(text string) (time.Duration, bool) {
+	if !muteDurationPattern.MatchString(text) {
+		return 0, false
+	}
+	var duration time.Duration
+	for _, part := range muteDurationPart.FindAllStringSubmatch(text, -1) {
+		value, err := strconv.Atoi(part[1])
+		if err != nil {
+			return 0, false
+		}
+		unit := map[string]time.Duration{
+			"s": time.Second,
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour,
+		}[part[2]]
+		if value > int(maxMuteDuration/unit) {
+			return 0, false
+		}
+		if duration += time.Duration(value) * unit; duration > maxMuteDuration {
+			return 0, false
+		}
+	}
+	return duration, duration > 0
+}
+
+// formats a duration for humans, i.e. 2d 3h or 45m
+func formatDuration(duration time.Duration) string {
+	if duration < time.Minute {
+		return "less than a minute"
+	}
+	minutes := int(duration / time.Minute)
+	parts := make([]string, 0, 3)
+	if days := minutes / (24 * 60); days > 0 {
+		parts = append(parts, strconv.Itoa(days)+"d")
+	}
+	if hours := minutes / 60 % 24; hours > 0 {
+		parts = append(parts, strconv.Itoa(hours)+"h")
+	}
+	if minutes%60 > 0 {
+		parts = append(parts, strconv.Itoa(minutes%60)+"m")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		text     string
+		duration time.Duration
+		ok       bool
+	}{
+		{"30s", 30 * time.Second, true},
+		{"30m", 30 * time.Minute, true},
+		{"2h", 2 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"1d12h", 36 * time.Hour, true},
+		{"1h1h", 2 * time.Hour, true},
+		{"520w", 520 * 7 * 24 * time.Hour, true},
+		{"0m", 0, false},
+		{"", 0, false},
+		{"2", 0, false},
+		{"h", 0, false},
+		{"2x", 0, false},
+		{"-2h", 0, false},
+		{"2h spamming", 0, false},
+		{"99999999w", 0, false},
+		{"3650d1s", 0, false},
+		{"9223372036854775807s", 0, false},
+		{"99999999999999999999s", 0, false},
+	}
+	for _, test := range tests {
+		duration, ok := parseMuteDuration(test.text)
+		if duration != test.duration || ok != test.ok {
+			t.Errorf("parseMuteDuration(%q) = %v, %v, want %v, %v", test.text, duration, ok, test.duration, test.ok)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		text     string
+	}{
+		{0, "less than a minute"},
+		{59 * time.Second, "less than a minute"},
+		{time.Minute, "1m"},
+		{90 * time.Minute, "1h 30m"},
+		{2 * time.Hour, "2h"},
+		{24 * time.Hour, "1d"},
+		{49*time.Hour + 5*time.Minute + 30*time.Second, "2d 1h 5m"},
+		{8 * 24 * time.Hour, "8d"},
+	}
+	for _, test := range tests {
+		if text := formatDuration(test.duration); text != test.text {
+			t.Errorf("formatDuration(%v) = %q, want %q", test.duration, text, test.text)
+		}
+	}
+}
+
+func TestParseMuteArguments(t *testing.T) {
+	tests := []struct {
+		fields   []string
+		duration time.Duration
+		reason   string
+	}{
+		{[]string{"<@11>"}, 0, ""},
+		{[]string{"<@11>", "2h"}, 2 * time.Hour, ""},
+		{[]string{"<@11>", "<@!12>", "1d", "spamming", "links"}, 24 * time.Hour, "spamming links"},
+		{[]string{"<@11>", "spamming", "2h"}, 0, "spamming 2h"},
+	}
+	for _, test := range tests {
+		duration, reason := parseMuteArguments(test.fields)
+		if duration != test.duration || reason != test.reason {
+			t.Errorf("parseMuteArguments(%q) = %v, %q, want %v, %q", test.fields, duration, reason, test.duration, test.reason)
+		}
+	}
+}
+
+func TestMutesExpire(t *testing.T) {
+	useConfig(t, &Configuration{})
+	previousMutes := muteStore.mutes
+	muteStore.mutes = make(map[string][]Mute)
+	defer func() { muteStore.mutes = previousMutes }()
+
+	now := time.Now()
+	muteStore.add("expiry", Mute{UserID: "1", MutedAt: now.Add(-2 * time.Hour), Expires: now.Add(-time.Minute)})
+	muteStore.add("expiry", Mute{UserID: "2", MutedAt: now, Expires: now.Add(time.Hour)})
+	muteStore.add("expiry", Mute{UserID: "3", MutedAt: now})
+
+	member := func(userID string) *discordgo.Member {
+		return &discordgo.Member{User: &discordgo.User{ID: userID}}
+	}
+	if muteStore.isMuted("expiry", member("1")) || !muteStore.isMuted("expiry", member("2")) || !muteStore.isMuted("expiry", member("3")) {
+		t.Error("expected only the expired mute to be inactive")
+	}
+
+	muteStore.expire()
+	var userIDs []string
+	for _, mute := range muteStore.mutes["expiry"] {
+		userIDs = append(userIDs, mute.UserID)
+	}
+	if !reflect.DeepEqual(userIDs, []string{"2", "3"}) {
+		t.Errorf("expected the expired mute to be removed, got %v", userIDs)
+	}
+
+	// the remaining mutes were saved
+	muteStore.mutes = nil
+	muteStore.load()
+	if mutes := muteStore.list("expiry"); len(mutes) != 2 {
+		t.Errorf("expected 2 stored mutes, got %+v", mutes)
+	}
+}
+
+func TestUnmutedConfigUserStaysUnmutedAfterReload(t *testing.T) {
+	brute := &discordgo.User{ID: "10", Username: "Brute", Discriminator: "0001"}
+	server := newServer("unmute-test", &ServerConfig{Muted: DiscordIdentityList{"10", "Spammer#0002"}})
+	if !server.unmuteConfigUser(brute) {
+		t.Fatal("expected the user to be unmuted")
+	}
+	if server.unmuteConfigUser(brute) {
+		t.Error("expected the user to be unmuted already")
+	}
+
+	server.setConfigMutes(DiscordIdentityList{"Brute#0001", "Spammer#0002", "Wooza#0003"})
+	if mutes := server.configMutes(); !reflect.DeepEqual(mutes, DiscordIdentityList{"Spammer#0002", "Wooza#0003"}) {
+		t.Errorf("unexpected config mutes %v", mutes)
+	}
+}
@@ -2,73 +2,104 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"os/user"
+	"strings"
+	"syscall"
 )
 
 const version = "v6.0.5"
 
-var configFile string
+var (
+	configFile  string
+	checkConfig bool
+)
 
 func main() {
 	// parse command line arguments
 	flag.StringVar(&configFile, "c", "config.toml", "Specify Configuration File")
+	flag.BoolVar(&checkConfig, "check", false, "Check the configuration file and exit")
 	flag.Parse()
-	
-	log.Println("Version", version)
-	
-	// Log current user information for debugging
-	currentUser, err := user.Current()
-	if err != nil {
-		log.Printf("WARNING: Could not determine current user: %v", err)
-	} else {
-		log.Printf("Running as user: %s (UID: %s, GID: %s)", currentUser.Username, currentUser.Uid, currentUser.Gid)
-		log.Printf("User home directory: %s", currentUser.HomeDir)
-	}
-	
-	// Log current working directory for debugging
-	cwd, err := os.Getwd()
-	if err != nil {
-		log.Printf("WARNING: Could not get current working directory: %v", err)
-	} else {
-		log.Printf("Current working directory: %s", cwd)
-		
-		// List contents of current directory
-		entries, err := os.ReadDir(cwd)
-		if err != nil {
-			log.Printf("WARNING: Could not read working directory: %v", err)
-		} else {
-			log.Printf("Working directory contains %d entries:", len(entries))
-			for i, entry := range entries {
-				if i < 20 { // Limit to first 20 entries to avoid spam
-					info, _ := entry.Info()
-					if info != nil {
-						log.Printf("  - %s (size: %d, mode: %v)", entry.Name(), info.Size(), info.Mode())
-					} else {
-						log.Printf("  - %s", entry.Name())
-					}
-				}
-			}
-			if len(entries) > 20 {
-				log.Printf("  ... and %d more entries", len(entries)-20)
-			}
+
+	if checkConfig {
+		if _, err := loadConfig(configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		fmt.Println("Config file", configFile, "is valid")
+		os.Exit(0)
 	}
-	
-	Config.loadConfig(configFile)
 
-	for serverName, v := range Config.Servers {
-		serverList[serverName] = &Server{
-			Name:   serverName,
-			Config: v,
-			Muted:  v.Muted,
-		}
-		log.Println("Linked server '"+serverName+"' to channel", v.ChannelID)
+	// like -check, the bridge doesn't start without a valid config
+	config, err := loadConfig(configFile)
+	if os.IsNotExist(err) {
+		subsystemLogger("main").Error("No configuration file found", "path", configFile)
+		os.Exit(1)
+	} else if err != nil {
+		subsystemLogger("main").Error("Invalid config file", "error", err)
+		os.Exit(1)
 	}
+	setConfig(config)
+	setupLogging(config)
+	logger := subsystemLogger("main")
+
+	logger.Info("Starting", "version", version)
+	logEnvironment(logger)
+
+	logPositions.load()
+	logPositions.startSaving()
+	muteStore.load()
+	muteStore.startExpiring()
+	avatarCache.load()
+	accountLinks.load()
+	avatarCache.startRefreshing()
+	messageArchive.open()
 
 	startDiscordBot()
-	startLogParser()
+	applyServerConfigs(Config())
+	statusBoards.start()
+	startHTTPServer()
+	startMetricsServer()
+	watchConfig()
 
-	select {}
+	// keep running until we are told to stop, then store how far the logs have been read
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	logger.Info("Shutting down")
+	logPositions.save()
+	messageArchive.close()
+}
+
+// logs the user and working directory at the debug level, which helps to find permission problems
+func logEnvironment(logger *slog.Logger) {
+	if currentUser, err := user.Current(); err != nil {
+		logger.Debug("Could not determine current user", "error", err)
+	} else {
+		logger.Debug("Running as user", "user", currentUser.Username, "uid", currentUser.Uid, "gid", currentUser.Gid, "home", currentUser.HomeDir)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		logger.Debug("Could not get current working directory", "error", err)
+		return
+	}
+	entries, err := os.ReadDir(cwd)
+	if err != nil {
+		logger.Debug("Could not read working directory", "dir", cwd, "error", err)
+		return
+	}
+	names := make([]string, 0, 20)
+	for i, entry := range entries {
+		// limited to the first entries to avoid spam
+		if i >= 20 {
+			names = append(names, fmt.Sprintf("... and %d more", len(entries)-20))
+			break
+		}
+		names = append(names, entry.Name())
+	}
+	logger.Debug("Working directory", "dir", cwd, "entries", strings.Join(names, ", "))
 }
@@ -0,0 +1,120 @@
+// This file contains the queue of messages that go from Discord to a game server.
+// The mod fetches them from the HTTP server by long polling and acknowledges what it received, so messages are
+// delivered at least once and survive a map change, during which the game server is not reachable
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	outboundQueueSize   = 100
+	outboundPollTimeout = 25 * time.Second
+	// the mod still counts as polling for this long after its last poll ended, which covers a map change
+	outboundPollGrace = 2 * time.Minute
+)
+
+type OutboundMessage struct {
+	ID      uint64 `json:"id"`
+	Type    string `json:"type"`
+	User    string `json:"user,omitempty"`
+	Message string `json:"msg"`
+}
+
+type OutboundQueue struct {
+	sync.Mutex
+	messages []OutboundMessage
+	nextID   uint64
+	// closed and replaced whenever a message is added, to wake up waiting polls
+	added chan struct{}
+	// the number of polls that are waiting, and when the last one ended
+	polls    int
+	lastPoll time.Time
+}
+
+func newOutboundQueue() *OutboundQueue {
+	return &OutboundQueue{
+		// ids start with the current time, so acknowledgements from before a restart of the bridge don't match new messages
+		// (in milliseconds, so the ids stay exact when the game parses them as numbers)
+		nextID: uint64(time.Now().UnixNano()/int64(time.Millisecond)) * 1000,
+		added:  make(chan struct{}),
+	}
+}
+
+// adds a message to the queue, dropping the oldest message when the queue is full
+func (queue *OutboundQueue) push(messageType string, user string, message string) {
+	queue.Lock()
+	defer queue.Unlock()
+	if len(queue.messages) >= outboundQueueSize {
+		dropped := queue.messages[0]
+		queue.messages = queue.messages[1:]
+		subsystemLogger("outboundqueue").Warn("Queue is full, dropped message", "type", dropped.Type, "id", dropped.ID)
+	}
+	queue.nextID++
+	queue.messages = append(queue.messages, OutboundMessage{
+		ID:      queue.nextID,
+		Type:    messageType,
+		User:    user,
+		Message: message,
+	})
+	close(queue.added)
+	queue.added = make(chan struct{})
+}
+
+// removes all messages up to and including the given id
+func (queue *OutboundQueue) acknowledge(id uint64) {
+	queue.Lock()
+	defer queue.Unlock()
+	i := 0
+	for i < len(queue.messages) && queue.messages[i].ID <= id {
+		i++
+	}
+	queue.messages = queue.messages[i:]
+}
+
+/* acknowledges the messages the game already received and returns the pending ones
+ * blocks until there is a pending message, the poll timeout elapsed or the request was cancelled
+ */
+func (queue *OutboundQueue) poll(ctx context.Context, ack uint64) []OutboundMessage {
+	queue.acknowledge(ack)
+	queue.Lock()
+	queue.polls++
+	queue.Unlock()
+	defer func() {
+		queue.Lock()
+		queue.polls--
+		queue.lastPoll = time.Now()
+		queue.Unlock()
+	}()
+
+	timeout := time.NewTimer(outboundPollTimeout)
+	defer timeout.Stop()
+	for {
+		queue.Lock()
+		pending := make([]OutboundMessage, len(queue.messages))
+		copy(pending, queue.messages)
+		added := queue.added
+		queue.Unlock()
+
+		if len(pending) > 0 {
+			return pending
+		}
+		select {
+		case <-added:
+		case <-timeout.C:
+			return pending
+		case <-ctx.Done():
+			return pending
+		}
+	}
+}
+
+// returns true while the mod polls the queue, or did so recently
+func (queue *OutboundQueue) isPolled() bool {
+	queue.Lock()
+	defer queue.Unlock()
+	return queue.polls > 0 || (!queue.lastPoll.IsZero() && time.Since(queue.lastPoll) < outboundPollGrace)
+}
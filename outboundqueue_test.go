@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func pollNow(queue *OutboundQueue, ack uint64) []OutboundMessage {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return queue.poll(ctx, ack)
+}
+
+func TestOutboundQueueRedeliversUntilAcknowledged(t *testing.T) {
+	queue := newOutboundQueue()
+	queue.push("chat", "Brute", "one")
+	queue.push("chat", "Brute", "two")
+
+	first := pollNow(queue, 0)
+	if len(first) != 2 || first[0].Message != "one" || first[1].Message != "two" || first[0].ID >= first[1].ID {
+		t.Fatalf("unexpected messages %+v", first)
+	}
+	// the response got lost, so the mod acknowledges nothing
+	if again := pollNow(queue, 0); len(again) != 2 || again[0].ID != first[0].ID {
+		t.Errorf("expected the messages to be delivered again, got %+v", again)
+	}
+	if rest := pollNow(queue, first[0].ID); len(rest) != 1 || rest[0].Message != "two" {
+		t.Errorf("expected only the unacknowledged message, got %+v", rest)
+	}
+	if rest := pollNow(queue, first[1].ID); len(rest) != 0 {
+		t.Errorf("expected no messages after acknowledging all, got %+v", rest)
+	}
+}
+
+func TestOutboundQueueIgnoresOldAcknowledgements(t *testing.T) {
+	queue := newOutboundQueue()
+	queue.push("chat", "Brute", "one")
+	// an id from before a restart of the bridge
+	if messages := pollNow(queue, 1000); len(messages) != 1 {
+		t.Errorf("expected the message to stay in the queue, got %+v", messages)
+	}
+}
+
+func TestOutboundQueueDropsOldestWhenFull(t *testing.T) {
+	queue := newOutboundQueue()
+	for i := 0; i < outboundQueueSize+2; i++ {
+		queue.push("chat", "Brute", string(rune('a'+i%26)))
+	}
+	messages := pollNow(queue, 0)
+	if len(messages) != outboundQueueSize || messages[0].Message != "c" {
+		t.Errorf("expected the two oldest messages to be dropped, got %d messages starting with %+v", len(messages), messages[0])
+	}
+}
+
+func TestOutboundQueuePollWaitsForMessages(t *testing.T) {
+	queue := newOutboundQueue()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.push("rcon", "", "sv_status")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if messages := queue.poll(ctx, 0); len(messages) != 1 || messages[0].Type != "rcon" {
+		t.Errorf("expected the poll to return the new message, got %+v", messages)
+	}
+}
+
+func TestServerFallsBackToWebAdmin(t *testing.T) {
+	server := newServer("outbound-test", &ServerConfig{WebAdmin: "http://localhost:8080"})
+	if server.usesOutboundQueue() {
+		t.Error("expected web admin to be used before the mod polls")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Outbound.poll(ctx, 0)
+		close(done)
+	}()
+	for !server.Outbound.isPolled() {
+		time.Sleep(time.Millisecond)
+	}
+	if !server.usesOutboundQueue() {
+		t.Error("expected the queue to be used while the mod polls")
+	}
+	cancel()
+	<-done
+	if !server.usesOutboundQueue() {
+		t.Error("expected the queue to be used right after a poll")
+	}
+
+	// the mod stopped polling
+	server.Outbound.Lock()
+	server.Outbound.lastPoll = time.Now().Add(-outboundPollGrace)
+	server.Outbound.Unlock()
+	if server.usesOutboundQueue() {
+		t.Error("expected web admin to be used again")
+	}
+
+	if server := newServer("outbound-test", &ServerConfig{}); !server.usesOutboundQueue() {
+		t.Error("expected the queue to be used without web admin")
+	}
+}
@@ -0,0 +1,200 @@
+// This file contains the capturing of the console output of rcon commands.
+// Web admin usually answers an rcon command with its page instead of the output, so the output is read from the
+// log lines the server writes right after the command. The output is sent back to Discord in a code block
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// how long to wait for the first line of output
+	rconOutputTimeout = 3 * time.Second
+	// the output is complete when the server did not write anything for this long
+	rconOutputQuietTime = 500 * time.Millisecond
+	rconOutputMaxTime   = 10 * time.Second
+	rconOutputMaxLines  = 1000
+	// longer output is attached as a file
+	rconOutputMaxPages = 3
+)
+
+// collects the log lines of a server while a command runs
+type LogCapture struct {
+	lines chan string
+}
+
+type LogCaptures struct {
+	sync.Mutex
+	captures []*LogCapture
+}
+
+func (server *Server) startLogCapture() *LogCapture {
+	capture := &LogCapture{lines: make(chan string, rconOutputMaxLines)}
+	server.logCaptures.Lock()
+	defer server.logCaptures.Unlock()
+	server.logCaptures.captures = append(server.logCaptures.captures, capture)
+	return capture
+}
+
+func (server *Server) stopLogCapture(capture *LogCapture) {
+	server.logCaptures.Lock()
+	defer server.logCaptures.Unlock()
+	for i, c := range server.logCaptures.captures {
+		if c == capture {
+			server.logCaptures.captures = append(server.logCaptures.captures[:i], server.logCaptures.captures[i+1:]...)
+			return
+		}
+	}
+}
+
+// passes a line of the log file to the running captures, the lines of the bridge mod are not output of a command
+func (server *Server) captureLogLine(line string) {
+	if strings.Contains(line, logEventMarker) {
+		return
+	}
+	server.logCaptures.Lock()
+	defer server.logCaptures.Unlock()
+	if len(server.logCaptures.captures) == 0 {
+		return
+	}
+	line = logTimestampPattern.ReplaceAllString(strings.TrimRight(line, "\r\n"), "")
+	for _, capture := range server.logCaptures.captures {
+		select {
+		case capture.lines <- line:
+		default:
+		}
+	}
+}
+
+// waits for the output of a command, until the server stops writing
+func (capture *LogCapture) collect() []string {
+	var lines []string
+	deadline := time.After(rconOutputMaxTime)
+	quiet := time.NewTimer(rconOutputTimeout)
+	defer quiet.Stop()
+	for {
+		select {
+		case line := <-capture.lines:
+			lines = append(lines, line)
+			if !quiet.Stop() {
+				<-quiet.C
+			}
+			quiet.Reset(rconOutputQuietTime)
+		case <-quiet.C:
+			return lines
+		case <-deadline:
+			return lines
+		}
+	}
+}
+
+func (r *ResponseHandler) sendRconCommand() error {
+	command := r.argumentText("command")
+	if allowed, reason := r.server.checkRconCommand(r.author, command); !allowed {
+		auditLog.record(r.rconAuditEntry(command, "denied"))
+		return errors.New(reason)
+	}
+	capture := r.server.startLogCapture()
+	defer r.server.stopLogCapture(capture)
+
+	var output string
+	if r.server.usesOutboundQueue() {
+		r.server.Outbound.push("rcon", "", command)
+	} else {
+		var err error
+		if output, err = r.server.WebAdmin.sendRcon(command); err != nil {
+			serverLogger("webadmin", r.server.Name).Error("Could not send rcon command", "error", err)
+			auditLog.record(r.rconAuditEntry(command, "failed: "+err.Error()))
+			r.respond(webAdminErrorMessage(r.server.Name, err))
+			return nil
+		}
+	}
+	auditLog.record(r.rconAuditEntry(command, "sent"))
+
+	if strings.TrimSpace(output) == "" {
+		if r.server.tailerAlive().IsZero() {
+			r.respond("Sent `" + escapeCodeSpan(command) + "` to server '" + r.server.Name + "', the output can only be shown with a log_file_path.")
+			return nil
+		}
+		output = strings.Join(capture.collect(), "\n")
+	}
+	r.respondRconOutput(command, output)
+	return nil
+}
+
+// kicks and bans are recorded as such, with the player as target
+func (r *ResponseHandler) rconAuditEntry(command string, outcome string) AuditEntry {
+	entry := r.auditEntry("rcon", command, outcome)
+	fields := strings.Fields(command)
+	if strings.Contains(command, ";") || len(fields) < 2 {
+		return entry
+	}
+	switch name := strings.ToLower(fields[0]); {
+	case strings.HasPrefix(name, "sv_kick"):
+		entry.Action = "kick"
+	case strings.HasPrefix(name, "sv_ban"):
+		entry.Action = "ban"
+	default:
+		return entry
+	}
+	entry.Target = fields[1]
+	return entry
+}
+
+func (r *ResponseHandler) respondRconOutput(command string, output string) {
+	header := "Output of `" + escapeCodeSpan(command) + "` on server '" + r.server.Name + "':"
+	output = strings.TrimSpace(output)
+	if output == "" {
+		r.respond("Sent `" + escapeCodeSpan(command) + "` to server '" + r.server.Name + "', it printed nothing.")
+		return
+	}
+
+	pages := splitIntoPages(strings.ReplaceAll(output, "```", "'''"), maxMessageLength-len(header)-len("\n```\n\n```"))
+	if len(pages) > rconOutputMaxPages {
+		r.respondFile(header, &discordgo.File{
+			Name:        "rcon-output.txt",
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(output + "\n"),
+		})
+		return
+	}
+	for i, page := range pages {
+		if i == 0 {
+			page = header + "\n```\n" + page + "\n```"
+		} else {
+			page = "```\n" + page + "\n```"
+		}
+		r.respond(page)
+	}
+}
+
+// splits text at line breaks into pages of at most maxLength bytes, longer lines are cut
+func splitIntoPages(text string, maxLength int) []string {
+	var pages []string
+	var page []string
+	length := 0
+	for _, line := range strings.Split(text, "\n") {
+		line = truncateUTF8(line, maxLength)
+		if len(page) > 0 && length+1+len(line) > maxLength {
+			pages = append(pages, strings.Join(page, "\n"))
+			page, length = nil, 0
+		}
+		if len(page) > 0 {
+			length++
+		}
+		page = append(page, line)
+		length += len(line)
+	}
+	return append(pages, strings.Join(page, "\n"))
+}
+
+// makes text safe to be shown between single backticks
+func escapeCodeSpan(text string) string {
+	return strings.ReplaceAll(text, "`", "'")
+}
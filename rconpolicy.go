@@ -0,0 +1,65 @@
+// This file contains the rcon policy of a server, which limits the console commands an admin may run.
+// The policy is a list of rules that map discord identities to command patterns like "sv_kick" or "sv_*".
+// Without a policy every admin may run every command
+
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// returns the command patterns the member may run, nil if the server has no policy
+func (server *Server) allowedRconCommands(member *discordgo.Member) []string {
+	if len(server.Config().RconPolicy) == 0 {
+		return nil
+	}
+	patterns := []string{}
+	for _, rule := range server.Config().RconPolicy {
+		if rule.Identities.isInList(member) {
+			patterns = append(patterns, rule.Commands...)
+		}
+	}
+	return patterns
+}
+
+/* checks the console commands against the policy of the server, and explains why they are not allowed.
+ * several commands can be given separated by ';', every one of them has to be allowed
+ */
+func (server *Server) checkRconCommand(member *discordgo.Member, commands string) (bool, string) {
+	patterns := server.allowedRconCommands(member)
+	if patterns == nil {
+		return true, ""
+	}
+	if len(patterns) == 0 {
+		return false, "You may not run console commands on server '" + server.Name + "'"
+	}
+	for _, command := range strings.Split(commands, ";") {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		if !matchesRconPattern(patterns, fields[0]) {
+			return false, "You may not run `" + escapeCodeSpan(fields[0]) + "` on server '" + server.Name + "'. Allowed are: " + strings.Join(patterns, ", ")
+		}
+	}
+	return true, ""
+}
+
+// a pattern matches the name of a single command, * and ? are wildcards
+func validRconPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil && pattern != "" && !strings.ContainsAny(pattern, " ;")
+}
+
+// console commands are not case sensitive, so neither are the patterns
+func matchesRconPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); matched {
+			return true
+		}
+	}
+	return false
+}
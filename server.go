@@ -5,27 +5,126 @@
 package main
 
 import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 )
 
-type ServerList map[string]*Server
+// the servers are replaced when the config is reloaded, so all access goes through the lock
+type ServerList struct {
+	sync.RWMutex
+	servers map[string]*Server
+}
 
-var serverList ServerList
+var serverList = &ServerList{servers: make(map[string]*Server)}
 
 type Server struct {
-	Name   string
-	Config ServerConfig
-	Admins DiscordIdentityList
-	Muted  DiscordIdentityList
+	Name string
+	// replaced as a whole when the config is reloaded
+	config   atomic.Pointer[ServerConfig]
+	Admins   DiscordIdentityList
+	Outbound *OutboundQueue
+	WebAdmin *WebAdminClient
+	// the muted list of the config without the users that were unmuted, who stay unmuted until the next restart
+	mutedLock    sync.Mutex
+	muted        DiscordIdentityList
+	unmutedUsers []*discordgo.User
+	// the log lines are passed to these while rcon commands run
+	logCaptures LogCaptures
+	// closed to stop the log parser of the server
+	stopTailer chan struct{}
+	// when the log parser last woke up (unix nanoseconds), zero while it isn't running
+	tailerAliveAt int64
+}
+
+func newServer(name string, config *ServerConfig) *Server {
+	server := &Server{
+		Name:     name,
+		muted:    config.Muted,
+		Outbound: newOutboundQueue(),
+	}
+	server.config.Store(config)
+	server.WebAdmin = newWebAdminClient(server)
+	return server
+}
+
+func (server *Server) Config() *ServerConfig {
+	return server.config.Load()
+}
+
+// the message style of the server, falls back to the global one
+func (server *Server) messageStyle() string {
+	if server.Config().MessageStyle != "" {
+		return server.Config().MessageStyle
+	}
+	return Config().Discord.MessageStyle
+}
+
+func (server *Server) richStyle() MessageStyleRichConfig {
+	return server.Config().MessageStyles.Rich.inherit(Config().MessageStyles.Rich)
+}
+
+func (server *Server) textStyle() MessageStyleTextConfig {
+	return server.Config().MessageStyles.Text.inherit(Config().MessageStyles.Text)
+}
+
+// called by the log parser whenever it wakes up, so stalled parsers can be detected
+func (server *Server) markTailerAlive() {
+	atomic.StoreInt64(&server.tailerAliveAt, time.Now().UnixNano())
+}
+
+func (server *Server) tailerAlive() time.Time {
+	if alive := atomic.LoadInt64(&server.tailerAliveAt); alive != 0 {
+		return time.Unix(0, alive)
+	}
+	return time.Time{}
+}
+
+func (serverList *ServerList) get(name string) (server *Server, success bool) {
+	serverList.RLock()
+	defer serverList.RUnlock()
+	server, success = serverList.servers[name]
+	return
+}
+
+// returns all servers, ordered by name
+func (serverList *ServerList) all() []*Server {
+	serverList.RLock()
+	defer serverList.RUnlock()
+	servers := make([]*Server, 0, len(serverList.servers))
+	for _, v := range serverList.servers {
+		servers = append(servers, v)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers
+}
+
+func (serverList *ServerList) count() int {
+	serverList.RLock()
+	defer serverList.RUnlock()
+	return len(serverList.servers)
 }
 
-func init() {
-	serverList = make(map[string]*Server)
+func (serverList *ServerList) add(server *Server) {
+	serverList.Lock()
+	defer serverList.Unlock()
+	serverList.servers[server.Name] = server
 }
 
-func (serverList ServerList) getServerByChannelID(channelID string) (server *Server, success bool) {
-	for _, v := range serverList {
-		if v.Config.ChannelID == channelID {
+func (serverList *ServerList) remove(name string) {
+	serverList.Lock()
+	defer serverList.Unlock()
+	delete(serverList.servers, name)
+}
+
+func (serverList *ServerList) getServerByChannelID(channelID string) (server *Server, success bool) {
+	serverList.RLock()
+	defer serverList.RUnlock()
+	for _, v := range serverList.servers {
+		if v.Config().ChannelID == channelID {
 			return v, true
 		}
 	}
@@ -33,9 +132,55 @@ func (serverList ServerList) getServerByChannelID(channelID string) (server *Ser
 }
 
 func (server *Server) isAdmin(member *discordgo.Member) bool {
-	return server.Config.Admins.isInList(member)
+	return server.Config().Admins.isInList(member)
 }
 
 func (server *Server) isMuted(member *discordgo.Member) bool {
-	return server.Muted.isInList(member)
+	return server.configMutes().isInList(member) || muteStore.isMuted(server.Name, member)
+}
+
+// the users and roles that are muted in the config
+func (server *Server) configMutes() DiscordIdentityList {
+	server.mutedLock.Lock()
+	defer server.mutedLock.Unlock()
+	return append(DiscordIdentityList(nil), server.muted...)
+}
+
+// applies the muted list of a reloaded config, users that were unmuted with the unmute command stay unmuted
+func (server *Server) setConfigMutes(muted DiscordIdentityList) {
+	server.mutedLock.Lock()
+	defer server.mutedLock.Unlock()
+	server.muted = make(DiscordIdentityList, 0, len(muted))
+nextIdentity:
+	for _, identity := range muted {
+		for _, user := range server.unmutedUsers {
+			if identity.matchesUser(user) {
+				continue nextIdentity
+			}
+		}
+		server.muted = append(server.muted, identity)
+	}
+}
+
+// removes the entries of the config's muted list that match the user, returns false if there were none
+func (server *Server) unmuteConfigUser(user *discordgo.User) bool {
+	server.mutedLock.Lock()
+	defer server.mutedLock.Unlock()
+	unmuted := false
+	for i := len(server.muted) - 1; i >= 0; i-- {
+		if server.muted[i].matchesUser(user) {
+			server.muted = append(server.muted[:i:i], server.muted[i+1:]...)
+			unmuted = true
+		}
+	}
+	if unmuted {
+		server.unmutedUsers = append(server.unmutedUsers, user)
+	}
+	return unmuted
+}
+
+// decides whether messages to the game go through the outbound queue or are posted to web admin.
+// the queue is used while the mod polls it, or if there is no web admin to post to
+func (server *Server) usesOutboundQueue() bool {
+	return server.Config().WebAdmin == "" || server.Outbound.isPolled()
 }
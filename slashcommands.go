@@ -0,0 +1,134 @@
+// This file contains the Discord application (slash) commands.
+// They are registered in every guild the bot is in and run the same handlers as the prefix commands
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// registers the slash commands whenever the bot joins a guild (or the guild becomes available on startup)
+func guildCreateEventHandler(_ *discordgo.Session, g *discordgo.GuildCreate) {
+	registerSlashCommands(g.Guild)
+}
+
+func interactionEventHandler(_ *discordgo.Session, i *discordgo.InteractionCreate) {
+	handleInteraction(i)
+}
+
+func registerSlashCommands(g *discordgo.Guild) {
+	slashCommands := commandRegistry.slashCommands()
+	if _, err := discord.ApplicationCommandBulkOverwrite(g.ID, slashCommands); err != nil {
+		subsystemLogger("discord").Error("Could not register slash commands", "guild", g.Name, "error", err)
+		return
+	}
+	subsystemLogger("discord").Info("Registered slash commands", "guild", g.Name, "count", len(slashCommands))
+}
+
+func handleInteraction(i *discordgo.InteractionCreate) {
+	// ignore everything that isn't a command inside a guild
+	if i.Type != discordgo.InteractionApplicationCommand || i.Member == nil {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	var flags discordgo.MessageFlags
+	if command, ok := commandRegistry.find(data.Name); ok && command.Ephemeral() {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	// acknowledge right away, as Discord only waits 3 seconds for the response, and web admin might be slower than that
+	err := discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: flags},
+	})
+	if err != nil {
+		subsystemLogger("discord").Error("Could not respond to interaction", "error", err)
+		return
+	}
+
+	responseHandler, finish := createInteractionResponseHandler(i, data, flags)
+	if responseHandler == nil {
+		finish()
+		return
+	}
+	commandRegistry.dispatch(data.Name, responseHandler)
+	finish()
+}
+
+/* creates a response handler whose responses answer the interaction
+ * the returned function has to be called after the command was handled, it makes sure the interaction got an answer
+ */
+func createInteractionResponseHandler(i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData, flags discordgo.MessageFlags) (*ResponseHandler, func()) {
+	responded := false
+	send := func(params *discordgo.WebhookParams) {
+		if !responded {
+			responded = true
+			edit := &discordgo.WebhookEdit{Content: &params.Content}
+			if params.Embeds != nil {
+				edit.Embeds = &params.Embeds
+			}
+			edit.Files = params.Files
+			_, _ = discord.InteractionResponseEdit(i.Interaction, edit)
+			return
+		}
+		params.Flags = flags
+		_, _ = discord.FollowupMessageCreate(i.Interaction, true, params)
+	}
+	finish := func() {
+		if !responded {
+			send(&discordgo.WebhookParams{Content: "Done."})
+		}
+	}
+
+	guild, err := discord.Guild(i.GuildID)
+	if err != nil {
+		subsystemLogger("discord").Error("Could not get guild for interaction", "guild_id", i.GuildID, "error", err)
+		return nil, finish
+	}
+	author, err := discord.Member(i.GuildID, i.Member.User.ID)
+	if err != nil {
+		author = i.Member
+		author.GuildID = i.GuildID
+	}
+
+	var mentions []*discordgo.User
+	options := map[string]string{}
+	for _, option := range data.Options {
+		switch option.Type {
+		case discordgo.ApplicationCommandOptionUser:
+			if data.Resolved != nil && data.Resolved.Users[option.Value.(string)] != nil {
+				mentions = append(mentions, data.Resolved.Users[option.Value.(string)])
+			} else if user, err := discord.User(option.Value.(string)); err == nil {
+				mentions = append(mentions, user)
+			} else {
+				mentions = append(mentions, &discordgo.User{ID: option.Value.(string)})
+			}
+		default:
+			// the handlers look the options up by name, as Discord sends them in the order the user filled them in
+			options[option.Name] = fmt.Sprint(option.Value)
+		}
+	}
+
+	return &ResponseHandler{
+		func(text string) {
+			send(&discordgo.WebhookParams{Content: text})
+		},
+		func(embed *discordgo.MessageEmbed) {
+			send(&discordgo.WebhookParams{Embeds: []*discordgo.MessageEmbed{embed}})
+		},
+		func(text string, file *discordgo.File) {
+			send(&discordgo.WebhookParams{Content: text, Files: []*discordgo.File{file}})
+		},
+		discord,
+		i.ChannelID,
+		guild,
+		author,
+		mentions,
+		nil,
+		options,
+		nil,
+	}, finish
+}
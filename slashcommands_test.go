@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var interactionIDs uint64 = 600
+
+func slashCommand(channelID string, userID string, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        strconv.FormatUint(atomic.AddUint64(&interactionIDs, 1), 10),
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   "1",
+		ChannelID: channelID,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID}},
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}}
+}
+
+func slashOption(optionType discordgo.ApplicationCommandOptionType, name string, value interface{}) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Type: optionType, Name: name, Value: value}
+}
+
+func TestSlashCommandsAreRegistered(t *testing.T) {
+	fake, _ := newTestBridge(t, "220", ServerConfig{})
+	registerSlashCommands(&discordgo.Guild{ID: "1", Name: "NS2"})
+	fake.Lock()
+	defer fake.Unlock()
+	if len(fake.commands["1"]) != len(commandRegistry.commands) {
+		t.Errorf("expected %d commands, got %d", len(commandRegistry.commands), len(fake.commands["1"]))
+	}
+}
+
+func TestSlashCommandIsAnswered(t *testing.T) {
+	fake, _ := newTestBridge(t, "221", ServerConfig{})
+
+	interaction := slashCommand("221", "10", "version")
+	handleInteraction(interaction)
+	if message := fake.nextMessage(t); message.InteractionID != interaction.ID || message.Ephemeral || message.Content != "Version "+version {
+		t.Errorf("unexpected answer %+v", message)
+	}
+}
+
+func TestSlashCommandsWithPrivateReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		ephemeral bool
+	}{
+		{"channelinfo", true},
+		{"mute", true},
+		{"unmute", true},
+		{"rcon", true},
+		{"version", false},
+		{"status", false},
+	}
+	for _, test := range tests {
+		command, ok := commandRegistry.find(test.name)
+		if !ok || command.Ephemeral() != test.ephemeral {
+			t.Errorf("%s: ephemeral = %v, want %v", test.name, ok && command.Ephemeral(), test.ephemeral)
+		}
+	}
+
+	// the ids of the channel, guild and roles are only shown to the user who asked for them
+	fake, _ := newTestBridge(t, "224", ServerConfig{})
+	handleInteraction(slashCommand("224", "10", "channelinfo"))
+	if message := fake.nextMessage(t); !message.Ephemeral || !strings.Contains(message.Content, "Guild 'NS2' Id: 1") {
+		t.Errorf("expected an ephemeral answer with the ids, got %+v", message)
+	}
+}
+
+func TestSlashRconKeepsTheCommandAsIs(t *testing.T) {
+	fake, server := newTestBridge(t, "222", ServerConfig{Admins: DiscordIdentityList{"10"}})
+
+	handleInteraction(slashCommand("222", "10", "rcon", slashOption(discordgo.ApplicationCommandOptionString, "command", `sv_say "two  spaces"`)))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if messages := server.Outbound.poll(ctx, 0); len(messages) != 1 || messages[0].Message != `sv_say "two  spaces"` {
+		t.Errorf("expected the command unchanged in the outbound queue, got %+v", messages)
+	}
+	// answers to admin commands are only shown to the admin
+	if message := fake.nextMessage(t); !message.Ephemeral {
+		t.Errorf("expected an ephemeral answer, got %+v", message)
+	}
+}
+
+func TestSlashMuteTakesOptionsByName(t *testing.T) {
+	fake, server := newTestBridge(t, "223", ServerConfig{Admins: DiscordIdentityList{"10"}})
+	defer muteStore.remove(server.Name, "11")
+
+	// the options arrive in the order the user filled them in, and the reason looks like a duration
+	handleInteraction(slashCommand("223", "10", "mute",
+		slashOption(discordgo.ApplicationCommandOptionString, "reason", "2h of spam"),
+		slashOption(discordgo.ApplicationCommandOptionUser, "user", "11"),
+		slashOption(discordgo.ApplicationCommandOptionString, "duration", "1d"),
+	))
+	if message := fake.nextMessage(t); message.Content != "Muted 1 user(s) for 1d" {
+		t.Errorf("unexpected answer %+v", message)
+	}
+	mutes := muteStore.list(server.Name)
+	if len(mutes) != 1 || mutes[0].Reason != "2h of spam" {
+		t.Errorf("unexpected mutes %+v", mutes)
+	}
+
+	handleInteraction(slashCommand("223", "10", "mute",
+		slashOption(discordgo.ApplicationCommandOptionUser, "user", "11"),
+		slashOption(discordgo.ApplicationCommandOptionString, "duration", "soon"),
+	))
+	if message := fake.nextMessage(t); message.Content != "Invalid duration 'soon', use i.e. 30m, 2h or 7d" {
+		t.Errorf("unexpected answer %+v", message)
+	}
+}
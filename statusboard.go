@@ -0,0 +1,228 @@
+// This file contains the status board, a message in the status channel of a server that always shows its current state.
+// The message is created once and then edited in place. It is refreshed after game events and polled from web admin
+// periodically, servers without web admin only show what is known from the events
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	statusBoardsFile        = "statusboards.json"
+	statusBoardPollInterval = time.Minute
+	// events are collected for a moment, so a map change with a full server doesn't edit the message for every player
+	statusBoardRefreshDelay = 3 * time.Second
+)
+
+// the message of a status board, this is stored in the state file
+type StatusBoardMessage struct {
+	ChannelID string `json:"channelID"`
+	MessageID string `json:"messageID"`
+}
+
+type StatusBoard struct {
+	sync.Mutex
+	serverName string
+	message    StatusBoardMessage
+	// the last known state, from web admin or the events
+	info      ServerInfo
+	scheduled bool
+	// a new message is on its way
+	creating bool
+}
+
+type StatusBoards struct {
+	sync.Mutex
+	boards map[string]*StatusBoard
+}
+
+var statusBoards = &StatusBoards{boards: make(map[string]*StatusBoard)}
+
+func init() {
+	eventBus.subscribe(updateStatusBoard)
+}
+
+// loads the messages of the status boards and starts the periodic refresh
+func (boards *StatusBoards) start() {
+	messages := make(map[string]StatusBoardMessage)
+	path := dataFilePath(statusBoardsFile)
+	if err := loadJSONFile(path, &messages); err != nil && !os.IsNotExist(err) {
+		subsystemLogger("statusboard").Error("Could not read status boards", "path", path, "error", err)
+	}
+	boards.Lock()
+	for serverName, message := range messages {
+		boards.boards[serverName] = &StatusBoard{serverName: serverName, message: message}
+	}
+	boards.Unlock()
+
+	go func() {
+		for {
+			for _, server := range serverList.all() {
+				if server.Config().StatusBoard {
+					boards.get(server.Name).refresh(server)
+				}
+			}
+			time.Sleep(statusBoardPollInterval)
+		}
+	}()
+}
+
+func (boards *StatusBoards) get(serverName string) *StatusBoard {
+	boards.Lock()
+	defer boards.Unlock()
+	board, ok := boards.boards[serverName]
+	if !ok {
+		board = &StatusBoard{serverName: serverName}
+		boards.boards[serverName] = board
+	}
+	return board
+}
+
+func (boards *StatusBoards) save() {
+	boards.Lock()
+	defer boards.Unlock()
+	messages := make(map[string]StatusBoardMessage)
+	for serverName, board := range boards.boards {
+		board.Lock()
+		if board.message.MessageID != "" {
+			messages[serverName] = board.message
+		}
+		board.Unlock()
+	}
+	path := dataFilePath(statusBoardsFile)
+	if err := saveJSONFile(path, messages); err != nil {
+		subsystemLogger("statusboard").Error("Could not save status boards", "path", path, "error", err)
+	}
+}
+
+// applies an event to the state of the status board and schedules a refresh
+func updateStatusBoard(server *Server, event LogEvent) {
+	if !server.Config().StatusBoard {
+		return
+	}
+	board := statusBoards.get(server.Name)
+	board.Lock()
+	switch event := event.(type) {
+	case PlayerEvent:
+		board.info.setPlayerCount(event.PlayerCount)
+	case StatusEvent:
+		board.info.State = event.State
+		board.info.Map = event.Map
+		board.info.setPlayerCount(event.PlayerCount)
+	case ChangeMapEvent:
+		board.info.State = "Changing map"
+		board.info.Map = event.Map
+		board.info.setPlayerCount(event.PlayerCount)
+	case InitEvent:
+		board.info.Map = event.Map
+	default:
+		board.Unlock()
+		return
+	}
+	schedule := !board.scheduled
+	board.scheduled = true
+	board.Unlock()
+
+	if schedule {
+		time.AfterFunc(statusBoardRefreshDelay, func() {
+			board.refresh(server)
+		})
+	}
+}
+
+// sets the player count from the events, which look like 12/24
+func (info *ServerInfo) setPlayerCount(playerCount string) {
+	parts := strings.SplitN(playerCount, "/", 2)
+	if numPlayers, err := strconv.Atoi(parts[0]); err == nil {
+		info.NumPlayers = numPlayers
+	}
+	if len(parts) == 2 {
+		if maxPlayers, err := strconv.Atoi(parts[1]); err == nil {
+			info.MaxPlayers = maxPlayers
+		}
+	}
+}
+
+// the status board is posted to the status channel, or to the chat channel if there is none
+func (server *Server) statusBoardChannelID() string {
+	if server.Config().StatusChannelID != "" {
+		return server.Config().StatusChannelID
+	}
+	return server.Config().ChannelID
+}
+
+// edits the message of the status board, or creates it if there is none in the channel yet
+func (board *StatusBoard) refresh(server *Server) {
+	var info ServerInfo
+	var fetched bool
+	if server.Config().WebAdmin != "" {
+		var err error
+		if info, err = server.WebAdmin.serverInfo(); err == nil {
+			fetched = true
+		} else {
+			serverLogger("statusboard", server.Name).Warn("Could not get the server info", "error", err)
+		}
+	}
+
+	board.Lock()
+	defer board.Unlock()
+	board.scheduled = false
+	if fetched {
+		board.info = info
+	} else {
+		info = board.info
+	}
+
+	channelID := server.statusBoardChannelID()
+	embed := buildServerStatusEmbed(server, MessageType{GroupType: "info", SubType: "info"}, info)
+	if messageID := board.message.MessageID; messageID != "" && board.message.ChannelID == channelID {
+		queueDiscordMessage(channelID, &DiscordMessage{
+			Embed:  embed,
+			EditID: messageID,
+			OnSent: func(message *discordgo.Message, err error) {
+				if hasDiscordStatus(err, http.StatusNotFound) {
+					// the message was deleted, a new one is created with the next refresh
+					board.forget(messageID)
+				}
+			},
+		})
+		return
+	}
+
+	if board.creating {
+		return
+	}
+	board.creating = true
+	queueDiscordMessage(channelID, &DiscordMessage{
+		Embed: embed,
+		OnSent: func(message *discordgo.Message, err error) {
+			board.Lock()
+			board.creating = false
+			if err == nil {
+				board.message = StatusBoardMessage{ChannelID: channelID, MessageID: message.ID}
+			}
+			board.Unlock()
+			if err == nil {
+				serverLogger("statusboard", board.serverName).Info("Created status board", "channel_id", channelID)
+				statusBoards.save()
+			}
+		},
+	})
+}
+
+func (board *StatusBoard) forget(messageID string) {
+	board.Lock()
+	if board.message.MessageID == messageID {
+		board.message = StatusBoardMessage{}
+	}
+	board.Unlock()
+	statusBoards.save()
+}
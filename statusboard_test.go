@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waits until the condition holds, i.e. until the queue worker called back
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func statusBoardMessageID(board *StatusBoard) string {
+	board.Lock()
+	defer board.Unlock()
+	return board.message.MessageID
+}
+
+func TestStatusBoardIsRecreatedAfterDeletion(t *testing.T) {
+	fake, server := newTestBridge(t, "260", ServerConfig{StatusBoard: true, StatusChannelID: "261"})
+	board := statusBoards.get(server.Name)
+	t.Cleanup(func() {
+		statusBoards.Lock()
+		delete(statusBoards.boards, server.Name)
+		statusBoards.Unlock()
+	})
+
+	// the events update the board, the refresh is not scheduled again as one is pending already
+	board.scheduled = true
+	updateStatusBoard(server, StatusEvent{State: "Started", Map: "ns2_veil", PlayerCount: "12/24"})
+
+	board.refresh(server)
+	created := fake.nextMessage(t)
+	if created.ChannelID != "261" || created.Edit || len(created.Embeds) != 1 || !strings.Contains(created.Embeds[0].Description, "**Map:** ns2_veil") || !strings.Contains(created.Embeds[0].Description, "12/24") {
+		t.Fatalf("expected the status board in the status channel, got %+v", created)
+	}
+	waitFor(t, func() bool { return statusBoardMessageID(board) == created.ID })
+
+	board.refresh(server)
+	if edit := fake.nextMessage(t); !edit.Edit || edit.ID != created.ID {
+		t.Errorf("expected the status board to be edited, got %+v", edit)
+	}
+
+	// somebody deleted the message
+	fake.failNext(discordStatusError(http.StatusNotFound))
+	board.refresh(server)
+	waitFor(t, func() bool { return statusBoardMessageID(board) == "" })
+	fake.expectNoMessage(t)
+
+	board.refresh(server)
+	recreated := fake.nextMessage(t)
+	if recreated.Edit || recreated.ID == created.ID || recreated.ChannelID != "261" {
+		t.Errorf("expected a new status board, got %+v", recreated)
+	}
+	waitFor(t, func() bool { return statusBoardMessageID(board) == recreated.ID })
+
+	// the message is stored, so it is edited again after a restart. It is saved right after it was set
+	waitFor(t, func() bool {
+		messages := make(map[string]StatusBoardMessage)
+		err := loadJSONFile(dataFilePath(statusBoardsFile), &messages)
+		return err == nil && messages[server.Name].MessageID == recreated.ID
+	})
+}
+
+func TestSetPlayerCount(t *testing.T) {
+	tests := []struct {
+		playerCount string
+		numPlayers  int
+		maxPlayers  int
+	}{
+		{"12/24", 12, 24},
+		{"3", 3, 20},
+		{"", 5, 20},
+		{"x/y", 5, 20},
+	}
+	for _, test := range tests {
+		info := ServerInfo{NumPlayers: 5, MaxPlayers: 20}
+		info.setPlayerCount(test.playerCount)
+		if info.NumPlayers != test.numPlayers || info.MaxPlayers != test.maxPlayers {
+			t.Errorf("setPlayerCount(%q) = %d/%d, want %d/%d", test.playerCount, info.NumPlayers, info.MaxPlayers, test.numPlayers, test.maxPlayers)
+		}
+	}
+}
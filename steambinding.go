@@ -5,9 +5,12 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -43,17 +46,38 @@ type SteamPlayer struct {
 }
 
 type Avatar struct {
-	url         string
-	lastUpdated time.Time
+	URL     string    `json:"url"`
+	Updated time.Time `json:"updated"`
 }
 
-var myClient = &http.Client{Timeout: 10 * time.Second}
-var AvatarCache map[SteamID3]*Avatar
-
-func init() {
-	AvatarCache = make(map[SteamID3]*Avatar)
+/* the avatars of the players, stored in a state file so they are known right after a restart
+ * missing and outdated avatars are fetched in the background, so sending a message never waits for Steam
+ */
+type AvatarCache struct {
+	sync.RWMutex
+	avatars map[SteamID3]*Avatar
+	// ids that are waiting to be fetched
+	pending map[SteamID3]bool
+	wake    chan struct{}
 }
 
+const (
+	avatarsFile          = "avatars.json"
+	avatarMaxAge         = 24 * time.Hour
+	avatarBatchDelay     = time.Second
+	maxSteamIDsPerLookup = 100
+	defaultSteamAPIURL   = "https://api.steampowered.com"
+)
+
+var (
+	myClient    = &http.Client{Timeout: 10 * time.Second}
+	avatarCache = &AvatarCache{
+		avatars: make(map[SteamID3]*Avatar),
+		pending: make(map[SteamID3]bool),
+		wake:    make(chan struct{}, 1),
+	}
+)
+
 func (steamID SteamID3) to64() SteamID64 {
 	return SteamID64(steamBaseline + uint64(steamID))
 }
@@ -62,21 +86,13 @@ func (steamID SteamID64) String() string {
 	return strconv.FormatUint(uint64(steamID), 10)
 }
 
+func (steamID SteamID64) to3() SteamID3 {
+	return SteamID3(uint64(steamID) - steamBaseline)
+}
+
+// returns the cached avatar, a missing or outdated one is fetched in the background for the next message
 func (steamID SteamID3) getAvatar() string {
-	if avatar, ok := AvatarCache[steamID]; ok {
-		if time.Now().Before(avatar.lastUpdated.Add(time.Duration(24) * time.Hour)) {
-			return avatar.url
-		}
-	}
-	steamProfile, err := steamID.getSteamProfile()
-	if err == nil {
-		AvatarCache[steamID] = &Avatar{
-			url:         steamProfile.Avatar,
-			lastUpdated: time.Now(),
-		}
-		return steamProfile.Avatar
-	}
-	return ""
+	return avatarCache.get(steamID)
 }
 
 func (steamID SteamID3) getSteamProfileLink() string {
@@ -86,37 +102,145 @@ func (steamID SteamID3) getSteamProfileLink() string {
 	return "https://steamcommunity.com/profiles/" + steamID.to64().String()
 }
 
-func getJson(url string, target interface{}) error {
-	r, err := myClient.Get(url)
-	if err != nil {
-		return err
+func (cache *AvatarCache) load() {
+	cache.Lock()
+	defer cache.Unlock()
+	path := dataFilePath(avatarsFile)
+	if err := loadJSONFile(path, &cache.avatars); err != nil && !os.IsNotExist(err) {
+		subsystemLogger("steam").Error("Could not read avatars", "path", path, "error", err)
+	}
+	if cache.avatars == nil {
+		cache.avatars = make(map[SteamID3]*Avatar)
+	}
+}
+
+func (cache *AvatarCache) save() {
+	cache.RLock()
+	defer cache.RUnlock()
+	path := dataFilePath(avatarsFile)
+	if err := saveJSONFile(path, cache.avatars); err != nil {
+		subsystemLogger("steam").Error("Could not save avatars", "path", path, "error", err)
+	}
+}
+
+func (cache *AvatarCache) get(steamID SteamID3) string {
+	if steamID == 0 || Config().Steam.WebApiKey == "" {
+		return ""
+	}
+	cache.RLock()
+	avatar, ok := cache.avatars[steamID]
+	cache.RUnlock()
+	if ok && time.Since(avatar.Updated) < avatarMaxAge {
+		return avatar.URL
+	}
+
+	cache.Lock()
+	cache.pending[steamID] = true
+	cache.Unlock()
+	select {
+	case cache.wake <- struct{}{}:
+	default:
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+	if ok {
+		return avatar.URL
+	}
+	return ""
+}
+
+// fetches the pending avatars, ids that come in shortly after each other are fetched together
+func (cache *AvatarCache) startRefreshing() {
+	go func() {
+		for range cache.wake {
+			time.Sleep(avatarBatchDelay)
+			cache.refresh()
+		}
+	}()
+}
+
+func (cache *AvatarCache) refresh() {
+	cache.Lock()
+	steamIDs := make([]SteamID3, 0, len(cache.pending))
+	for steamID := range cache.pending {
+		steamIDs = append(steamIDs, steamID)
+	}
+	cache.pending = make(map[SteamID3]bool)
+	cache.Unlock()
+
+	changed := false
+	for start := 0; start < len(steamIDs); start += maxSteamIDsPerLookup {
+		end := start + maxSteamIDsPerLookup
+		if end > len(steamIDs) {
+			end = len(steamIDs)
+		}
+		batch := steamIDs[start:end]
+		players, err := getPlayerSummaries(batch)
 		if err != nil {
+			subsystemLogger("steam").Warn("Could not fetch avatars", "count", len(batch), "error", err)
+			// the players are fetched again with the next batch
+			cache.Lock()
+			for _, steamID := range batch {
+				cache.pending[steamID] = true
+			}
+			cache.Unlock()
+			continue
 		}
-	}(r.Body)
 
-	return json.NewDecoder(r.Body).Decode(target)
+		now := time.Now()
+		cache.Lock()
+		// players that are not in the response (i.e. deleted accounts) are remembered without avatar, so they aren't fetched for every message
+		for _, steamID := range batch {
+			cache.avatars[steamID] = &Avatar{Updated: now}
+		}
+		for _, player := range players {
+			if steamID, err := strconv.ParseUint(player.SteamID, 10, 64); err == nil {
+				cache.avatars[SteamID64(steamID).to3()] = &Avatar{URL: player.Avatar, Updated: now}
+			}
+		}
+		cache.Unlock()
+		changed = true
+	}
+	if changed {
+		cache.save()
+	}
 }
 
-func (steamID SteamID3) getSteamProfile() (*SteamPlayer, error) {
-	if steamID == 0 {
-		return nil, errors.New("invalid Steamid")
+// the base url of the Steam Web API, can be changed to test against a local stand-in
+func steamAPIURL() string {
+	if Config().Steam.ApiBaseUrl != "" {
+		return strings.TrimSuffix(Config().Steam.ApiBaseUrl, "/")
+	}
+	return defaultSteamAPIURL
+}
+
+func getJson(url string, target interface{}) error {
+	r, err := myClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errors.New("unexpected response: " + r.Status)
 	}
+	return json.NewDecoder(r.Body).Decode(target)
+}
 
-	if Config.Steam.WebApiKey == "" {
+// fetches the profiles of up to 100 players with one request
+func getPlayerSummaries(steamIDs []SteamID3) ([]SteamPlayer, error) {
+	if Config().Steam.WebApiKey == "" {
 		return nil, errors.New("no Steam Web API Key set")
 	}
+	ids := make([]string, len(steamIDs))
+	for i, steamID := range steamIDs {
+		ids[i] = steamID.to64().String()
+	}
 
 	steamResponse := ISteamUser{}
-	url := "https://api.steampowered.com/ISteamUser/GetPlayerSummaries/v0002/?key=" + Config.Steam.WebApiKey + "&steamids=" + steamID.to64().String()
-	if err := getJson(url, &steamResponse); err != nil {
-		return nil, err
+	query := url.Values{
+		"key":      {Config().Steam.WebApiKey},
+		"steamids": {strings.Join(ids, ",")},
 	}
-	if len(steamResponse.Response.Players) == 0 {
-		return nil, errors.New("empty response")
+	if err := getJson(steamAPIURL()+"/ISteamUser/GetPlayerSummaries/v0002/?"+query.Encode(), &steamResponse); err != nil {
+		return nil, err
 	}
-	steamProfile := &steamResponse.Response.Players[0]
-	return steamProfile, nil
+	return steamResponse.Response.Players, nil
 }
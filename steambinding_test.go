@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestAvatarCache(t *testing.T, handler http.HandlerFunc) *AvatarCache {
+	steamAPI := httptest.NewServer(handler)
+	t.Cleanup(steamAPI.Close)
+	config := &Configuration{}
+	config.Steam.WebApiKey = "key"
+	config.Steam.ApiBaseUrl = steamAPI.URL + "/"
+	useConfig(t, config)
+	return &AvatarCache{
+		avatars: make(map[SteamID3]*Avatar),
+		pending: make(map[SteamID3]bool),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// answers with an avatar for every requested player, except the ones with an even id
+func steamPlayerSummaries(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ISteamUser/GetPlayerSummaries/v0002/" || r.URL.Query().Get("key") != "key" {
+		t.Errorf("unexpected request %s", r.URL)
+	}
+	players := []string{}
+	for _, id := range strings.Split(r.URL.Query().Get("steamids"), ",") {
+		steamID, _ := strconv.ParseUint(id, 10, 64)
+		if SteamID64(steamID).to3()%2 == 1 {
+			players = append(players, `{"steamid":"`+id+`","avatar":"https://avatars/`+id+`.jpg"}`)
+		}
+	}
+	_, _ = w.Write([]byte(`{"response":{"players":[` + strings.Join(players, ",") + `]}}`))
+}
+
+func TestAvatarCacheBatchesLookups(t *testing.T) {
+	var requests int32
+	cache := newTestAvatarCache(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if count := len(strings.Split(r.URL.Query().Get("steamids"), ",")); count > maxSteamIDsPerLookup {
+			t.Errorf("expected at most %d ids per lookup, got %d", maxSteamIDsPerLookup, count)
+		}
+		steamPlayerSummaries(t, w, r)
+	})
+
+	for steamID := SteamID3(1); steamID <= 150; steamID++ {
+		if avatar := cache.get(steamID); avatar != "" {
+			t.Errorf("expected no avatar before the lookup, got %q", avatar)
+		}
+	}
+	cache.refresh()
+	if requests != 2 {
+		t.Errorf("expected 150 players to be fetched with 2 requests, got %d", requests)
+	}
+
+	if avatar := cache.get(1); avatar != "https://avatars/"+SteamID3(1).to64().String()+".jpg" {
+		t.Errorf("unexpected avatar %q", avatar)
+	}
+	// players without a profile are remembered as well
+	if avatar := cache.get(2); avatar != "" {
+		t.Errorf("expected no avatar, got %q", avatar)
+	}
+	if len(cache.pending) != 0 {
+		t.Errorf("expected cached avatars not to be fetched again, %d are pending", len(cache.pending))
+	}
+	cache.refresh()
+	if requests != 2 {
+		t.Errorf("expected no further requests, got %d", requests)
+	}
+}
+
+func TestAvatarCacheRetriesFailedLookups(t *testing.T) {
+	var fail int32 = 1
+	cache := newTestAvatarCache(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		steamPlayerSummaries(t, w, r)
+	})
+
+	cache.get(1)
+	cache.get(3)
+	cache.refresh()
+	if len(cache.pending) != 2 || len(cache.avatars) != 0 {
+		t.Fatalf("expected the players of the failed lookup to stay pending, got %v", cache.pending)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	cache.refresh()
+	if avatar := cache.get(3); avatar != "https://avatars/"+SteamID3(3).to64().String()+".jpg" {
+		t.Errorf("unexpected avatar %q", avatar)
+	}
+	if len(cache.pending) != 0 {
+		t.Errorf("expected nothing to be pending, got %v", cache.pending)
+	}
+}
@@ -0,0 +1,44 @@
+// This file contains helpers for the files in which the bridge keeps its state between restarts
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// returns the path of a state file inside the configured data directory
+// without a configured directory the files are kept next to the config file
+func dataFilePath(name string) string {
+	dir := Config().Storage.DataDir
+	if dir == "" {
+		dir = filepath.Dir(configFile)
+	}
+	return filepath.Join(dir, name)
+}
+
+func loadJSONFile(path string, target interface{}) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, target)
+}
+
+// writes the file atomically, so a crash never leaves a half written state file behind
+func saveJSONFile(path string, source interface{}) error {
+	buf, err := json.MarshalIndent(source, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,206 @@
+// This file contains the client for the web admin interface of a game server.
+// Chat messages, rcon commands and the server info requests go through it. Requests time out, are retried if that is
+// safe and fail with a WebAdminError, which is shown to the Discord user as "unreachable"
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webAdminTimeout  = 5 * time.Second
+	webAdminAttempts = 3
+	// failed chat messages are reported in the channel at most this often
+	webAdminNoticeInterval = time.Minute
+)
+
+// the delay before the first retry, doubled for every further one
+var webAdminRetryDelay = 500 * time.Millisecond
+
+var errWebAdminNotConfigured = errors.New("no web admin configured")
+
+type WebAdminClient struct {
+	sync.Mutex
+	server     *Server
+	httpClient *http.Client
+	lastNotice time.Time
+}
+
+// a request to web admin that failed, after all retries
+type WebAdminError struct {
+	Server string
+	// the http status of the response, 0 if there was none
+	Status int
+	Err    error
+}
+
+func (err *WebAdminError) Error() string {
+	if err.Status != 0 {
+		return "web admin of server '" + err.Server + "' answered with " + http.StatusText(err.Status) + fmt.Sprintf(" (%d)", err.Status)
+	}
+	return "web admin of server '" + err.Server + "' is unreachable: " + err.Err.Error()
+}
+
+func (err *WebAdminError) Unwrap() error {
+	return err.Err
+}
+
+func (err *WebAdminError) rejectedCredentials() bool {
+	return err.Status == http.StatusUnauthorized || err.Status == http.StatusForbidden
+}
+
+// the credentials are read from the config of the server on every request, so a reloaded config applies right away
+func newWebAdminClient(server *Server) *WebAdminClient {
+	return &WebAdminClient{
+		server:     server,
+		httpClient: &http.Client{Timeout: webAdminTimeout},
+	}
+}
+
+// posts a form to web admin and returns the body of the response
+func (client *WebAdminClient) post(values url.Values) ([]byte, error) {
+	config := client.server.Config()
+	if config.WebAdmin == "" {
+		return nil, errWebAdminNotConfigured
+	}
+	logger := serverLogger("webadmin", client.server.Name)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var body []byte
+		var retry bool
+		body, retry, err = client.tryPost(config, values)
+		if err == nil {
+			return body, nil
+		}
+		if !retry || attempt == webAdminAttempts {
+			break
+		}
+		delay := webAdminRetryDelay << uint(attempt-1)
+		logger.Debug("Request failed, retrying", "request", webAdminRequestName(values), "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+	}
+	atomic.AddUint64(&metrics.server(client.server.Name).WebAdminFailures, 1)
+	return nil, err
+}
+
+/* sends a single request, and decides whether it is retried if it failed.
+ * network errors and server errors might go away, everything else (wrong credentials, missing pages, ...) would fail again.
+ * chat messages and rcon commands may have reached the server already though, so they are only sent again if the
+ * connection could not be made. A second sv_ban or a duplicated chat line is worse than a failed one
+ */
+func (client *WebAdminClient) tryPost(config *ServerConfig, values url.Values) (body []byte, retry bool, err error) {
+	idempotent := webAdminRequestName(values) == "discordinfo"
+	request, err := http.NewRequest(http.MethodPost, config.WebAdmin, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, false, &WebAdminError{Server: client.server.Name, Err: err}
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if config.WebAdminUser != "" {
+		request.SetBasicAuth(config.WebAdminUser, config.WebAdminPassword)
+	}
+
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, idempotent || isDialError(err), &WebAdminError{Server: client.server.Name, Err: err}
+	}
+	defer response.Body.Close()
+
+	body, err = ioutil.ReadAll(response.Body)
+	if response.StatusCode >= 300 {
+		return nil, idempotent && response.StatusCode >= 500, &WebAdminError{Server: client.server.Name, Status: response.StatusCode, Err: errors.New(response.Status)}
+	}
+	if err != nil {
+		return nil, idempotent, &WebAdminError{Server: client.server.Name, Err: err}
+	}
+	return body, false, nil
+}
+
+// whether a request failed before it was sent, because there was no connection to the server
+func isDialError(err error) bool {
+	var opError *net.OpError
+	return errors.As(err, &opError) && opError.Op == "dial"
+}
+
+func (client *WebAdminClient) sendChat(user string, message string) error {
+	_, err := client.post(url.Values{
+		"request": {"discordsend"},
+		"user":    {user},
+		"msg":     {message},
+	})
+	return err
+}
+
+// returns the console output of the command, if web admin answered with it instead of its page
+func (client *WebAdminClient) sendRcon(command string) (string, error) {
+	body, err := client.post(url.Values{
+		"rcon": {command},
+	})
+	if err != nil {
+		return "", err
+	}
+	output := strings.TrimSpace(string(body))
+	if strings.HasPrefix(output, "<") {
+		return "", nil
+	}
+	return output, nil
+}
+
+// requests the current state of the server
+func (client *WebAdminClient) serverInfo() (ServerInfo, error) {
+	serverInfo := ServerInfo{}
+	body, err := client.post(url.Values{
+		"request": {"discordinfo"},
+	})
+	if err != nil {
+		return serverInfo, err
+	}
+	if err := json.Unmarshal(body, &serverInfo); err != nil {
+		return serverInfo, errors.New("web admin of server '" + client.server.Name + "' sent an invalid server info: " + err.Error())
+	}
+	return serverInfo, nil
+}
+
+// decides whether a failed chat message is reported in the channel, so a server that is down doesn't flood it
+func (client *WebAdminClient) shouldNotify() bool {
+	client.Lock()
+	defer client.Unlock()
+	if time.Since(client.lastNotice) < webAdminNoticeInterval {
+		return false
+	}
+	client.lastNotice = time.Now()
+	return true
+}
+
+func webAdminRequestName(values url.Values) string {
+	if request := values.Get("request"); request != "" {
+		return request
+	}
+	return "rcon"
+}
+
+// the message shown in Discord when a request to web admin failed
+func webAdminErrorMessage(serverName string, err error) string {
+	var webAdminError *WebAdminError
+	switch {
+	case errors.Is(err, errWebAdminNotConfigured):
+		return "Server '" + serverName + "' has no web admin configured"
+	case errors.As(err, &webAdminError) && webAdminError.rejectedCredentials():
+		return "Server '" + serverName + "' rejected the web admin credentials"
+	case errors.As(err, &webAdminError):
+		return "Server '" + serverName + "' is unreachable"
+	default:
+		return "Server '" + serverName + "': " + err.Error()
+	}
+}
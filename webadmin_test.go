@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebAdmin(t *testing.T, handler http.HandlerFunc) *Server {
+	webAdmin := httptest.NewServer(handler)
+	t.Cleanup(webAdmin.Close)
+	previousDelay := webAdminRetryDelay
+	webAdminRetryDelay = time.Millisecond
+	t.Cleanup(func() { webAdminRetryDelay = previousDelay })
+	return newServer("webadmin", &ServerConfig{WebAdmin: webAdmin.URL, WebAdminUser: "admin", WebAdminPassword: "secret"})
+}
+
+func TestWebAdminRetriesServerErrors(t *testing.T) {
+	var requests int32
+	server := newTestWebAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			t.Errorf("expected the configured credentials, got %q %q", user, password)
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"map":"ns2_veil","numPlayers":12}`))
+	})
+
+	info, err := server.WebAdmin.serverInfo()
+	if err != nil || info.Map != "ns2_veil" || info.NumPlayers != 12 {
+		t.Errorf("unexpected server info %+v, error %v", info, err)
+	}
+	if requests != 2 {
+		t.Errorf("expected one retry, got %d requests", requests)
+	}
+}
+
+func TestWebAdminErrors(t *testing.T) {
+	var requests int32
+	server := newTestWebAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := server.WebAdmin.sendRcon("sv_say hi")
+	if message := webAdminErrorMessage(server.Name, err); message != "Server 'webadmin' rejected the web admin credentials" {
+		t.Errorf("unexpected message %q for %v", message, err)
+	}
+	if requests != 1 {
+		t.Errorf("wrong credentials must not be retried, got %d requests", requests)
+	}
+
+	server.Config().WebAdmin = "http://127.0.0.1:1"
+	err = server.WebAdmin.sendChat("Brute", "hello")
+	if message := webAdminErrorMessage(server.Name, err); message != "Server 'webadmin' is unreachable" {
+		t.Errorf("unexpected message %q for %v", message, err)
+	}
+}
+
+func TestWebAdminDoesNotRepeatCommands(t *testing.T) {
+	var requests int32
+	server := newTestWebAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.FormValue("msg") == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	server.WebAdmin.httpClient.Timeout = 50 * time.Millisecond
+
+	// the command or message may have run before the server failed, so it is not sent again
+	if _, err := server.WebAdmin.sendRcon("sv_ban 11345"); err == nil {
+		t.Error("expected the command to fail")
+	}
+	if err := server.WebAdmin.sendChat("Brute", "slow"); err == nil {
+		t.Error("expected the message to time out")
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("expected every request to be sent once, got %d requests", requests)
+	}
+}
+
+func TestWebAdminRetriesConnectionErrors(t *testing.T) {
+	server := newTestWebAdmin(t, func(w http.ResponseWriter, r *http.Request) {})
+	var dials int32
+	server.WebAdmin.httpClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+		},
+	}
+
+	if _, err := server.WebAdmin.sendRcon("sv_ban 11345"); err == nil {
+		t.Error("expected the command to fail")
+	}
+	if dials := atomic.LoadInt32(&dials); dials != webAdminAttempts {
+		t.Errorf("expected a command that never reached the server to be retried, got %d attempts", dials)
+	}
+}
@@ -0,0 +1,130 @@
+// This file contains the channel webhooks of the webhook message style.
+// Chat messages are posted through a webhook with the name and avatar of the player, so they look like native Discord messages.
+// The bridge creates one webhook per channel and reuses it. Until it is known, and in channels where the bot is not
+// allowed to manage webhooks, chat messages fall back to the multiline style
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	webhookName = "NS2 Discord Bridge"
+	// after a failed lookup the channel uses the multiline style for a while, the wait doubles with every failure
+	webhookRetryBaseDelay = 30 * time.Second
+	webhookRetryMaxDelay  = 30 * time.Minute
+)
+
+type ChannelWebhooks struct {
+	sync.Mutex
+	webhooks map[string]*discordgo.Webhook
+	// channels where the last lookup failed, they are not looked up again before the retry time
+	failures map[string]WebhookFailure
+	// channels whose webhook is being looked up
+	lookups map[string]bool
+}
+
+type WebhookFailure struct {
+	count   int
+	retryAt time.Time
+}
+
+var channelWebhooks = &ChannelWebhooks{
+	webhooks: make(map[string]*discordgo.Webhook),
+	failures: make(map[string]WebhookFailure),
+	lookups:  make(map[string]bool),
+}
+
+/* returns the webhook of the bridge for a channel, or nil if it is not known yet
+ * an unknown webhook is looked up (and created) in the background, so chat messages never wait for Discord.
+ * failures, including a missing Manage Webhooks permission, are remembered for a while
+ */
+func (hooks *ChannelWebhooks) get(channelID string) *discordgo.Webhook {
+	hooks.Lock()
+	defer hooks.Unlock()
+	if webhook, ok := hooks.webhooks[channelID]; ok {
+		return webhook
+	}
+	if hooks.lookups[channelID] || time.Now().Before(hooks.failures[channelID].retryAt) {
+		return nil
+	}
+	hooks.lookups[channelID] = true
+	go hooks.lookup(channelID)
+	return nil
+}
+
+func (hooks *ChannelWebhooks) lookup(channelID string) {
+	webhook, err := findOrCreateWebhook(channelID)
+
+	hooks.Lock()
+	defer hooks.Unlock()
+	delete(hooks.lookups, channelID)
+	if err == nil {
+		hooks.webhooks[channelID] = webhook
+		delete(hooks.failures, channelID)
+		return
+	}
+	failure := hooks.failures[channelID]
+	failure.count++
+	delay := webhookRetryBaseDelay << uint(failure.count-1)
+	if failure.count > 10 || delay > webhookRetryMaxDelay {
+		delay = webhookRetryMaxDelay
+	}
+	failure.retryAt = time.Now().Add(delay)
+	hooks.failures[channelID] = failure
+	if hasDiscordStatus(err, http.StatusForbidden) {
+		subsystemLogger("webhooks").Warn("Missing the Manage Webhooks permission, using the multiline style for a while", "channel_id", channelID, "retry_in", delay)
+		return
+	}
+	subsystemLogger("webhooks").Warn("Could not get the webhook of channel, using the multiline style for a while", "channel_id", channelID, "retry_in", delay, "error", err)
+}
+
+// forgets the webhook of a channel, i.e. because it was deleted, so it is looked up again with the next message
+func (hooks *ChannelWebhooks) forget(channelID string) {
+	hooks.Lock()
+	defer hooks.Unlock()
+	delete(hooks.webhooks, channelID)
+	delete(hooks.failures, channelID)
+}
+
+// forgets all failed lookups, so a permission that was granted in the meantime is used right away
+func (hooks *ChannelWebhooks) forgetFailures() {
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.failures = make(map[string]WebhookFailure)
+}
+
+func findOrCreateWebhook(channelID string) (*discordgo.Webhook, error) {
+	webhooks, err := discord.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.Name == webhookName && webhook.Token != "" && webhook.User != nil && webhook.User.ID == botID {
+			return webhook, nil
+		}
+	}
+	subsystemLogger("webhooks").Info("Creating webhook", "channel_id", channelID)
+	return discord.WebhookCreate(channelID, webhookName, "")
+}
+
+/* makes a player name usable as webhook username
+ * Discord rejects names longer than 80 characters and names containing "discord" or "clyde"
+ */
+func webhookUsername(name string) string {
+	replacer := strings.NewReplacer("discord", "disc0rd", "Discord", "Disc0rd", "DISCORD", "DISC0RD", "clyde", "clyd3", "Clyde", "Clyd3", "CLYDE", "CLYD3")
+	name = strings.TrimSpace(replacer.Replace(name))
+	if lower := strings.ToLower(name); strings.Contains(lower, "discord") || strings.Contains(lower, "clyde") {
+		name = "Player"
+	}
+	if name == "" {
+		return "Player"
+	}
+	return truncateUTF8(name, 80)
+}
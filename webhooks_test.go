@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func newWebhookTestBridge(t *testing.T, channelID string) (*FakeDiscordSession, *Server) {
+	fake, server := newTestBridge(t, channelID, ServerConfig{MessageStyle: "webhook"})
+	t.Cleanup(func() {
+		waitFor(t, func() bool { return !webhookLookupRunning(channelID) })
+		channelWebhooks.forget(channelID)
+	})
+	return fake, server
+}
+
+func webhookLookups(fake *FakeDiscordSession) int {
+	fake.Lock()
+	defer fake.Unlock()
+	return fake.webhookLookups
+}
+
+func webhookLookupRunning(channelID string) bool {
+	channelWebhooks.Lock()
+	defer channelWebhooks.Unlock()
+	return channelWebhooks.lookups[channelID]
+}
+
+func webhookFailure(channelID string) WebhookFailure {
+	channelWebhooks.Lock()
+	defer channelWebhooks.Unlock()
+	return channelWebhooks.failures[channelID]
+}
+
+// starts the lookup of the webhook of a channel and waits until it finished
+func lookUpWebhook(t *testing.T, channelID string) *discordgo.Webhook {
+	t.Helper()
+	channelWebhooks.get(channelID)
+	waitFor(t, func() bool { return !webhookLookupRunning(channelID) })
+	return channelWebhooks.get(channelID)
+}
+
+func TestChatIsPostedThroughWebhook(t *testing.T) {
+	fake, server := newWebhookTestBridge(t, "250")
+	if webhook := lookUpWebhook(t, "250"); webhook == nil {
+		t.Fatal("expected the webhook to be created")
+	}
+
+	for _, text := range []string{"hello", "again"} {
+		processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", text))
+		if message := fake.nextMessage(t); message.WebhookUsername != "Brute" || message.Content != text {
+			t.Errorf("expected the message to be posted as the player, got %+v", message)
+		}
+	}
+	if lookups := webhookLookups(fake); lookups != 1 {
+		t.Errorf("expected the webhook to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func TestChatDoesNotWaitForTheWebhook(t *testing.T) {
+	fake, server := newWebhookTestBridge(t, "254")
+	gate := make(chan struct{})
+	fake.Lock()
+	fake.webhookGate = gate
+	fake.Unlock()
+
+	// the lookup hangs, the message is posted in the multiline style meanwhile
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "hello"))
+	if message := fake.nextMessage(t); message.WebhookUsername != "" || len(message.Embeds) != 1 {
+		t.Errorf("expected the multiline style while the webhook is looked up, got %+v", message)
+	}
+	close(gate)
+	waitFor(t, func() bool { return !webhookLookupRunning("254") })
+
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "again"))
+	if message := fake.nextMessage(t); message.WebhookUsername != "Brute" || message.Content != "again" {
+		t.Errorf("expected the message to be posted through the webhook, got %+v", message)
+	}
+	if lookups := webhookLookups(fake); lookups != 1 {
+		t.Errorf("expected the webhook to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func TestWebhookFailuresAreRetriedLater(t *testing.T) {
+	fake, server := newWebhookTestBridge(t, "251")
+
+	fake.failNext(errors.New("connection reset"))
+	if webhook := lookUpWebhook(t, "251"); webhook != nil {
+		t.Fatalf("expected no webhook, got %+v", webhook)
+	}
+	for _, text := range []string{"hello", "again"} {
+		processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", text))
+		if message := fake.nextMessage(t); message.WebhookUsername != "" || len(message.Embeds) != 1 {
+			t.Errorf("expected the multiline style while the webhook is unavailable, got %+v", message)
+		}
+	}
+	if lookups := webhookLookups(fake); lookups != 1 {
+		t.Errorf("expected the failure to be remembered, got %d lookups", lookups)
+	}
+
+	channelWebhooks.Lock()
+	failure := channelWebhooks.failures["251"]
+	if delay := time.Until(failure.retryAt); failure.count != 1 || delay <= 0 || delay > webhookRetryBaseDelay {
+		t.Errorf("unexpected failure %+v", failure)
+	}
+	failure.retryAt = time.Now()
+	channelWebhooks.failures["251"] = failure
+	channelWebhooks.Unlock()
+
+	if webhook := lookUpWebhook(t, "251"); webhook == nil {
+		t.Fatal("expected the webhook to be looked up again after the backoff")
+	}
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "third"))
+	if message := fake.nextMessage(t); message.WebhookUsername != "Brute" {
+		t.Errorf("expected the webhook to be used after the backoff, got %+v", message)
+	}
+}
+
+func TestMissingWebhookPermissionIsRetriedAfterReload(t *testing.T) {
+	fake, _ := newWebhookTestBridge(t, "252")
+
+	fake.failNext(discordStatusError(http.StatusForbidden))
+	if webhook := lookUpWebhook(t, "252"); webhook != nil || webhookLookups(fake) != 1 {
+		t.Errorf("expected no webhook without looking it up again, got %+v", webhook)
+	}
+	if failure := webhookFailure("252"); failure.count != 1 || time.Until(failure.retryAt) <= 0 {
+		t.Errorf("expected the missing permission to be retried later, got %+v", failure)
+	}
+
+	// the permission was granted and the config reloaded
+	channelWebhooks.forgetFailures()
+	if webhook := lookUpWebhook(t, "252"); webhook == nil || webhookLookups(fake) != 2 {
+		t.Errorf("expected the webhook to be looked up again, got %+v", webhook)
+	}
+}
+
+func TestDeletedWebhookIsCreatedAgain(t *testing.T) {
+	fake, server := newWebhookTestBridge(t, "253")
+	lookUpWebhook(t, "253")
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "hello"))
+	fake.nextMessage(t)
+
+	// the webhook was deleted in Discord, so the message is lost, but the next one gets a new webhook
+	fake.Lock()
+	fake.webhooks["253"] = nil
+	fake.Unlock()
+	fake.failNext(discordStatusError(http.StatusNotFound))
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "lost"))
+	fake.expectNoMessage(t)
+
+	if webhook := lookUpWebhook(t, "253"); webhook == nil {
+		t.Fatal("expected a new webhook")
+	}
+	processLogLine(server.Name, server, discordLogLine("chat", "Brute", "12345", "1", "again"))
+	if message := fake.nextMessage(t); message.WebhookUsername != "Brute" || message.Content != "again" {
+		t.Errorf("expected the message to be posted through a new webhook, got %+v", message)
+	}
+	if lookups := webhookLookups(fake); lookups != 2 {
+		t.Errorf("expected the webhook to be looked up again, got %d lookups", lookups)
+	}
+}
+
+func TestWebhookUsername(t *testing.T) {
+	tests := map[string]string{
+		"Brute":         "Brute",
+		"  Brute ":      "Brute",
+		"":              "Player",
+		"Discord Admin": "Disc0rd Admin",
+		"dIsCoRd":       "Player",
+		"Clyde":         "Clyd3",
+		"a very long name that goes on and on and on and on and on and on and on and on and on": "a very long name that goes on and on and on and on and on and on and on and on a",
+	}
+	for name, expected := range tests {
+		if username := webhookUsername(name); username != expected {
+			t.Errorf("webhookUsername(%q) = %q, want %q", name, username, expected)
+		}
+	}
+}